@@ -0,0 +1,153 @@
+package coordinate
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Client manages the estimated network coordinate for a single node,
+// applying incoming RTT observations to nudge it via Vivaldi, and
+// smoothing each peer's samples with a small median filter to reduce the
+// effect of latency spikes.
+type Client struct {
+	mu     sync.RWMutex
+	coord  *Coordinate
+	origin *Coordinate
+	config *Config
+
+	// samples holds the most recent LatencyFilterSize RTTs (in seconds)
+	// observed for each peer, used to compute a median before feeding
+	// ApplyForce.
+	samples map[string][]float64
+
+	// adjustmentIndex and adjustmentSamples implement the sliding window
+	// used to smooth the adjustment term (see Config.AdjustmentWindowSize).
+	adjustmentIndex   uint
+	adjustmentSamples []float64
+}
+
+// NewClient creates a new Client at the origin coordinate.
+func NewClient(config *Config) (*Client, error) {
+	if config.Dimensionality == 0 {
+		return nil, fmt.Errorf("dimensionality must be > 0")
+	}
+
+	origin := NewCoordinate(config)
+	return &Client{
+		coord:             origin.Clone(),
+		origin:            origin,
+		config:            config,
+		samples:           make(map[string][]float64),
+		adjustmentSamples: make([]float64, config.AdjustmentWindowSize),
+	}, nil
+}
+
+// GetCoordinate returns a copy of the client's current coordinate.
+func (c *Client) GetCoordinate() *Coordinate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.coord.Clone()
+}
+
+// Update processes a new RTT observation to the given peer's coordinate,
+// updating the client's own coordinate estimate. rtt must not be negative;
+// a zero rtt is treated as 1µs.
+func (c *Client) Update(peer string, other *Coordinate, rtt time.Duration) (*Coordinate, error) {
+	if rtt < 0 {
+		return nil, fmt.Errorf("rtt must not be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sample := rtt.Seconds()
+	if sample <= 0 {
+		sample = 1.0e-6
+	}
+
+	filtered := c.filter(peer, sample)
+
+	updated, err := c.coord.ApplyForce(c.config, filtered, other)
+	if err != nil {
+		return nil, err
+	}
+	if !updated.IsValid() {
+		return nil, fmt.Errorf("update produced an invalid coordinate")
+	}
+	c.coord = updated
+
+	c.updateAdjustment(other, filtered)
+	c.updateGravity()
+
+	return c.coord.Clone(), nil
+}
+
+// updateAdjustment folds rtt into a sliding window of (rtt - raw distance)
+// samples and recomputes the adjustment term as half their mean over the
+// whole window (DistanceTo adds both ends' adjustments), which helps
+// correct for systematic error -- like an access link's queueing delay --
+// that the Euclidean+height model can't capture on its own. It's a no-op
+// if Config.AdjustmentWindowSize is zero, which disables the feature.
+func (c *Client) updateAdjustment(other *Coordinate, rtt float64) {
+	if c.config.AdjustmentWindowSize == 0 {
+		return
+	}
+
+	dist := c.coord.rawDistanceTo(other)
+	c.adjustmentSamples[c.adjustmentIndex] = rtt - dist
+	c.adjustmentIndex = (c.adjustmentIndex + 1) % c.config.AdjustmentWindowSize
+
+	sum := 0.0
+	for _, sample := range c.adjustmentSamples {
+		sum += sample
+	}
+	c.coord.Adjustment = sum / (2.0 * float64(c.config.AdjustmentWindowSize))
+}
+
+// updateGravity nudges the coordinate a small amount back towards the
+// origin, proportional to the square of how far out it's drifted. Without
+// this, small systematic errors that Update can't distinguish from real
+// movement tend to accumulate into unbounded drift over time.
+func (c *Client) updateGravity() {
+	dist := c.origin.DistanceTo(c.coord)
+	force := -1.0 * math.Pow(dist/c.config.GravityRho, 2.0)
+
+	if updated := c.coord.applyRawForce(c.config, force, c.origin); updated.IsValid() {
+		c.coord = updated
+	}
+}
+
+// filter pushes sample into the peer's latency window and returns the
+// median of the window so far.
+func (c *Client) filter(peer string, sample float64) float64 {
+	window := append(c.samples[peer], sample)
+	if uint(len(window)) > c.config.LatencyFilterSize {
+		window = window[len(window)-int(c.config.LatencyFilterSize):]
+	}
+	c.samples[peer] = window
+
+	sorted := append([]float64(nil), window...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j-1] > sorted[j]; j-- {
+			sorted[j-1], sorted[j] = sorted[j], sorted[j-1]
+		}
+	}
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// DistanceTo returns the estimated RTT between the client's current
+// coordinate and other.
+func (c *Client) DistanceTo(other *Coordinate) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dist := c.coord.DistanceTo(other)
+	return time.Duration(math.Max(dist, 0) * float64(time.Second))
+}
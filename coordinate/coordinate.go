@@ -0,0 +1,271 @@
+// Package coordinate implements a network coordinate system based on the
+// Vivaldi algorithm described in "Vivaldi: A Decentralized Network
+// Coordinate System" (Dabek et al). Each node maintains a coordinate in a
+// low-dimensional Euclidean space (plus a height term to capture the
+// effect of a slow access link) such that the distance between two
+// coordinates approximates the round-trip time observed between them.
+package coordinate
+
+import (
+	"errors"
+	"math"
+)
+
+// Config is used to tune the tradeoffs between accuracy and convergence
+// speed of the Vivaldi algorithm.
+type Config struct {
+	// Dimensionality is the dimension of the Euclidean coordinate space.
+	// Higher dimensions converge to a more accurate picture of the
+	// network at the cost of more gossip traffic.
+	Dimensionality uint
+
+	// VivaldiErrorMax is the default error value when a node doesn't
+	// have any previous observations.
+	VivaldiErrorMax float64
+
+	// VivaldiCE is a tuning factor that controls the maximum impact an
+	// observation can have on a node's confidence.
+	VivaldiCE float64
+
+	// VivaldiCC is a tuning factor that controls the maximum impact an
+	// observation can have on a node's coordinate.
+	VivaldiCC float64
+
+	// AdjustmentWindowSize is the size of the sliding window used to
+	// smooth the adjustment term, which helps account for systematic
+	// errors not captured by the Euclidean+height model.
+	AdjustmentWindowSize uint
+
+	// HeightMin is the minimum height the Vivaldi coordinate may have,
+	// to keep it from going to zero or negative.
+	HeightMin float64
+
+	// LatencyFilterSize is the number of samples to retain per-node in
+	// order to compute a median, which helps smooth out noisy RTTs.
+	LatencyFilterSize uint
+
+	// GravityRho controls how strongly a Client's coordinate is pulled
+	// back towards the origin: the pull grows with the square of the
+	// coordinate's distance from the origin divided by GravityRho.
+	GravityRho float64
+}
+
+// DefaultConfig returns values that were tuned for use with memberlist's
+// probe-based health checking.
+func DefaultConfig() *Config {
+	return &Config{
+		Dimensionality:       8,
+		VivaldiErrorMax:      1.5,
+		VivaldiCE:            0.25,
+		VivaldiCC:            0.25,
+		AdjustmentWindowSize: 20,
+		HeightMin:            10.0e-6,
+		LatencyFilterSize:    3,
+		GravityRho:           150.0,
+	}
+}
+
+// Coordinate is a specialized structure for holding network coordinates
+// for the Vivaldi-based coordinate mapping algorithm. All of the fields
+// should be public to enable this to be serialized.
+type Coordinate struct {
+	// Vec is the Euclidean portion of the coordinate.
+	Vec []float64
+
+	// Error reflects how confident we are in the given coordinate.
+	Error float64
+
+	// Adjustment is a distance offset computed based on a calculation
+	// over observations from all other nodes, to help correct for
+	// systematic error that the base Euclidean model can't capture.
+	Adjustment float64
+
+	// Height is a distance offset that accounts for the access link
+	// from a node to the rest of the network, which tends to dominate
+	// latencies for nodes behind a slow last-mile connection.
+	Height float64
+}
+
+// ErrDimensionalityConflict indicates that two coordinates are not
+// compatible based on their respective dimensionalities.
+var ErrDimensionalityConflict = errors.New("coordinate dimensionality does not match")
+
+// NewCoordinate creates a new coordinate at the origin, using the given
+// config to set its dimensionality and initial error estimate.
+func NewCoordinate(config *Config) *Coordinate {
+	return &Coordinate{
+		Vec:        make([]float64, config.Dimensionality),
+		Error:      config.VivaldiErrorMax,
+		Adjustment: 0.0,
+		Height:     config.HeightMin,
+	}
+}
+
+// Clone creates an independent copy of this coordinate.
+func (c *Coordinate) Clone() *Coordinate {
+	vec := make([]float64, len(c.Vec))
+	copy(vec, c.Vec)
+	return &Coordinate{
+		Vec:        vec,
+		Error:      c.Error,
+		Adjustment: c.Adjustment,
+		Height:     c.Height,
+	}
+}
+
+// IsValid returns false if any component of the coordinate isn't
+// finite, which indicates it's corrupt in some way from arithmetic
+// errors.
+func (c *Coordinate) IsValid() bool {
+	check := func(f float64) bool {
+		return !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+
+	for i := range c.Vec {
+		if !check(c.Vec[i]) {
+			return false
+		}
+	}
+
+	return check(c.Error) && check(c.Adjustment) && check(c.Height)
+}
+
+// checkDimensionality returns an error if the dimensionality of this
+// coordinate doesn't match the dimensionality of the given coordinate.
+func (c *Coordinate) checkDimensionality(other *Coordinate) error {
+	if len(c.Vec) != len(other.Vec) {
+		return ErrDimensionalityConflict
+	}
+	return nil
+}
+
+// DistanceTo returns the estimated RTT between this coordinate and other,
+// in the same units as the rtt samples used to feed ApplyForce (usually
+// time.Duration-compatible seconds).
+func (c *Coordinate) DistanceTo(other *Coordinate) float64 {
+	dist := c.rawDistanceTo(other)
+	adjustedDist := dist + c.Adjustment + other.Adjustment
+	if adjustedDist > 0.0 {
+		return adjustedDist
+	}
+	return dist
+}
+
+// rawDistanceTo computes the Euclidean+height distance, without the
+// error-correcting adjustment term.
+func (c *Coordinate) rawDistanceTo(other *Coordinate) float64 {
+	return magnitude(diff(c.Vec, other.Vec)) + c.Height + other.Height
+}
+
+// ApplyForce returns the result of updating a coordinate in response to
+// an observed round-trip time rtt (in seconds) to other, using the
+// classic Vivaldi spring-force update: the local coordinate is nudged
+// towards/away from other by an amount proportional to how wrong the
+// current estimate is, weighted by relative confidence.
+func (c *Coordinate) ApplyForce(config *Config, rtt float64, other *Coordinate) (*Coordinate, error) {
+	if err := c.checkDimensionality(other); err != nil {
+		return nil, err
+	}
+
+	dist := c.rawDistanceTo(other)
+	errEstimate := math.Abs(dist-rtt) / rtt
+	ce := updateWeightedError(config.VivaldiCE, c.Error, other.Error, errEstimate)
+
+	totalErr := c.Error + other.Error
+	if totalErr <= 0 {
+		totalErr = 1.0e-6
+	}
+	weight := c.Error / totalErr
+
+	force := config.VivaldiCC * weight * (rtt - dist)
+	ret := c.applyRawForce(config, force, other)
+	ret.Error = ce
+
+	return ret, nil
+}
+
+// applyRawForce nudges the coordinate by a precomputed force along the
+// line towards/away from other. It's split out from ApplyForce so callers
+// that already have a force in hand -- like the adjustment and gravity
+// terms in Client, which aren't derived from an RTT sample -- can reuse
+// the same Vec/Height update without going through ApplyForce's
+// RTT-to-force derivation. It leaves Error untouched; callers that have
+// an error estimate to fold in set it themselves afterward.
+func (c *Coordinate) applyRawForce(config *Config, force float64, other *Coordinate) *Coordinate {
+	dist := c.rawDistanceTo(other)
+	ret := c.Clone()
+	ret.Vec = add(ret.Vec, scale(unitVectorAt(c.Vec, other.Vec), force))
+
+	if c.Height+other.Height > 0 {
+		ret.Height += (c.Height + other.Height) * force / dist2(dist)
+		if ret.Height < config.HeightMin {
+			ret.Height = config.HeightMin
+		}
+	}
+
+	return ret
+}
+
+func dist2(dist float64) float64 {
+	if dist < 1.0e-6 {
+		return 1.0e-6
+	}
+	return dist
+}
+
+func updateWeightedError(ce, localErr, otherErr, sample float64) float64 {
+	weight := localErr / (localErr + otherErr)
+	if weight < 0 || weight > 1 {
+		weight = 0.5
+	}
+	return localErr + ce*weight*(sample-localErr)
+}
+
+func diff(a, b []float64) []float64 {
+	ret := make([]float64, len(a))
+	for i := range a {
+		ret[i] = a[i] - b[i]
+	}
+	return ret
+}
+
+func add(a, b []float64) []float64 {
+	ret := make([]float64, len(a))
+	for i := range a {
+		ret[i] = a[i] + b[i]
+	}
+	return ret
+}
+
+func scale(a []float64, f float64) []float64 {
+	ret := make([]float64, len(a))
+	for i := range a {
+		ret[i] = a[i] * f
+	}
+	return ret
+}
+
+func magnitude(a []float64) float64 {
+	sum := 0.0
+	for _, v := range a {
+		sum += v * v
+	}
+	return math.Sqrt(sum)
+}
+
+// unitVectorAt returns a unit vector pointing from other towards self. If
+// self and other coincide, the unit vector along the first axis is
+// returned so the update still nudges the coordinate apart.
+func unitVectorAt(self, other []float64) []float64 {
+	d := diff(self, other)
+	mag := magnitude(d)
+	if mag > 1.0e-6 {
+		return scale(d, 1.0/mag)
+	}
+
+	ret := make([]float64, len(self))
+	if len(ret) > 0 {
+		ret[0] = 1.0
+	}
+	return ret
+}
@@ -0,0 +1,108 @@
+package coordinate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCoordinate_NewCoordinate(t *testing.T) {
+	config := DefaultConfig()
+	c := NewCoordinate(config)
+
+	if len(c.Vec) != int(config.Dimensionality) {
+		t.Fatalf("bad vec length: %d", len(c.Vec))
+	}
+	if c.Error != config.VivaldiErrorMax {
+		t.Fatalf("bad initial error: %f", c.Error)
+	}
+	if !c.IsValid() {
+		t.Fatalf("origin coordinate should be valid")
+	}
+}
+
+func TestCoordinate_DistanceTo_origin(t *testing.T) {
+	config := DefaultConfig()
+	config.HeightMin = 0
+	a := NewCoordinate(config)
+	b := NewCoordinate(config)
+
+	// Two coordinates at the origin with no height and no adjustment
+	// should report exactly zero distance.
+	if d := a.DistanceTo(b); d != 0 {
+		t.Fatalf("expected zero distance between identical coordinates, got %f", d)
+	}
+}
+
+func TestCoordinate_ApplyForce_convergesTowardRTT(t *testing.T) {
+	config := DefaultConfig()
+	a := NewCoordinate(config)
+	b := NewCoordinate(config)
+
+	const rtt = 0.25 // 250ms
+
+	var err error
+	for i := 0; i < 1000; i++ {
+		a, err = a.ApplyForce(config, rtt, b)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	got := a.DistanceTo(b)
+	if math.Abs(got-rtt) > 0.05 {
+		t.Fatalf("expected distance to converge near %f, got %f", rtt, got)
+	}
+}
+
+func TestCoordinate_dimensionalityConflict(t *testing.T) {
+	config := DefaultConfig()
+	a := NewCoordinate(config)
+
+	otherConfig := DefaultConfig()
+	otherConfig.Dimensionality = config.Dimensionality + 1
+	b := NewCoordinate(otherConfig)
+
+	if _, err := a.ApplyForce(config, 0.1, b); err != ErrDimensionalityConflict {
+		t.Fatalf("expected dimensionality conflict, got %v", err)
+	}
+}
+
+func TestClient_Update(t *testing.T) {
+	config := DefaultConfig()
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	other := NewCoordinate(config)
+	const rtt = 100 * time.Millisecond
+
+	var coord *Coordinate
+	for i := 0; i < 1000; i++ {
+		coord, err = client.Update("other", other, rtt)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if !coord.IsValid() {
+		t.Fatalf("expected a valid coordinate")
+	}
+
+	got := client.DistanceTo(other)
+	if math.Abs(got.Seconds()-rtt.Seconds()) > 0.05 {
+		t.Fatalf("expected estimate near %s, got %s", rtt, got)
+	}
+}
+
+func TestClient_Update_negativeRTT(t *testing.T) {
+	client, err := NewClient(DefaultConfig())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := client.Update("other", NewCoordinate(DefaultConfig()), -1); err == nil {
+		t.Fatal("expected error for negative rtt")
+	}
+}
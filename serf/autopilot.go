@@ -0,0 +1,132 @@
+package serf
+
+import (
+	"time"
+)
+
+// AutopilotConfig is the configuration for Serf's autopilot subsystem. It
+// controls whether failed nodes are automatically removed from the
+// cluster, and the quorum safety checks that guard that removal.
+type AutopilotConfig struct {
+	// CleanupDeadServers controls whether Serf will automatically remove
+	// members that have been failed for longer than LastContactThreshold.
+	// If this is false the autopilot goroutine does not run at all, and
+	// RemoveFailedNode must still be called manually.
+	CleanupDeadServers bool
+
+	// MinQuorum is the minimum number of StatusAlive members that must
+	// remain after a cleanup pass. A pass is skipped entirely if the
+	// live member count is already at or below this value.
+	MinQuorum int
+
+	// LastContactThreshold is how long a member must have been in
+	// StatusFailed, based on its StatusTime, before it is eligible for
+	// automatic removal.
+	LastContactThreshold time.Duration
+
+	// DeadNodeCleanupInterval is how often the autopilot goroutine wakes
+	// up to look for dead nodes to remove.
+	DeadNodeCleanupInterval time.Duration
+}
+
+// DefaultAutopilotConfig returns an AutopilotConfig with sane, conservative
+// defaults. CleanupDeadServers is false by default so existing clusters
+// keep Serf's historical behavior of only removing failed nodes when an
+// operator calls RemoveFailedNode.
+func DefaultAutopilotConfig() *AutopilotConfig {
+	return &AutopilotConfig{
+		CleanupDeadServers:      false,
+		MinQuorum:               0,
+		LastContactThreshold:    200 * time.Millisecond,
+		DeadNodeCleanupInterval: 10 * time.Second,
+	}
+}
+
+// autopilot watches the member list for nodes that have been failed for a
+// while and removes them automatically, subject to a quorum check. It is
+// started alongside the reaper and handles a related but distinct job:
+// the reaper drops members only once they have been left for
+// TombstoneTimeout or failed for ReconnectTimeout, while autopilot removes
+// a failed member (via RemoveFailedNode) once it has been failed for
+// LastContactThreshold.
+type autopilot struct {
+	serf   *Serf
+	config *AutopilotConfig
+
+	shutdownCh chan struct{}
+}
+
+// newAutopilot creates an autopilot for the given Serf instance. Call run
+// to start its background loop.
+func newAutopilot(s *Serf, config *AutopilotConfig) *autopilot {
+	return &autopilot{
+		serf:       s,
+		config:     config,
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// run is a long running goroutine that periodically attempts to clean up
+// dead servers. It exits once the autopilot is stopped.
+func (a *autopilot) run() {
+	if !a.config.CleanupDeadServers {
+		return
+	}
+
+	ticker := time.NewTicker(a.config.DeadNodeCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.cleanupDeadServers()
+		case <-a.shutdownCh:
+			return
+		}
+	}
+}
+
+// cleanupDeadServers enumerates the current members, and removes any that
+// have been StatusFailed for longer than LastContactThreshold -- as long
+// as the current StatusAlive member count is above MinQuorum.
+func (a *autopilot) cleanupDeadServers() {
+	members := a.serf.Members()
+
+	liveCount := 0
+	var failed []Member
+	for _, m := range members {
+		switch m.Status {
+		case StatusAlive:
+			liveCount++
+		case StatusFailed:
+			if time.Since(m.StatusTime) >= a.config.LastContactThreshold {
+				failed = append(failed, m)
+			}
+		}
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+
+	if liveCount <= a.config.MinQuorum {
+		a.serf.logger.Printf("[WARN] serf: autopilot skipping removal of %d failed node(s): "+
+			"only %d alive member(s) remain, MinQuorum is %d", len(failed), liveCount, a.config.MinQuorum)
+		return
+	}
+
+	for _, m := range failed {
+		if err := a.serf.RemoveFailedNode(m.Name); err != nil {
+			a.serf.logger.Printf("[ERR] serf: autopilot failed to remove %s: %s", m.Name, err)
+			continue
+		}
+
+		mCopy := m
+		a.serf.fireEvent(EventMemberReap, &mCopy)
+	}
+}
+
+// Stop halts the autopilot background goroutine.
+func (a *autopilot) Stop() {
+	close(a.shutdownCh)
+}
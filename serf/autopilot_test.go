@@ -0,0 +1,109 @@
+package serf
+
+import (
+	"testing"
+	"time"
+)
+
+func testAutopilotConfig() *AutopilotConfig {
+	config := DefaultAutopilotConfig()
+	config.CleanupDeadServers = true
+	config.MinQuorum = 1
+	config.LastContactThreshold = 10 * time.Millisecond
+	config.DeadNodeCleanupInterval = 50 * time.Millisecond
+	return config
+}
+
+func TestAutopilot_removesFailedNode(t *testing.T) {
+	eventCh := make(chan Event, 4)
+	s1Config := testConfig()
+	s1Config.EventCh = eventCh
+	s1Config.Autopilot = testAutopilotConfig()
+
+	s2Config := testConfig()
+	s3Config := testConfig()
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s3, err := Create(s3Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defer s1.Shutdown()
+	defer s2.Shutdown()
+	defer s3.Shutdown()
+
+	if _, err := s1.Join([]string{s2Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s1.Join([]string{s3Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberCount(t, s1, 3)
+
+	// Force s2 to appear failed.
+	if err := s2.Shutdown(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Autopilot should clean s2 up on its own, since 2 other members
+	// remain alive and MinQuorum is 1. The thresholds above are tight
+	// enough that s2 can be detected failed and then reaped (StatusLeft
+	// is tombstoned almost immediately by testConfig's ReapInterval) well
+	// within a single poll, so watch the event stream rather than
+	// polling Members for an intermediate status that may never be
+	// observed.
+	assertEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberReap}, 5*time.Second)
+}
+
+func TestAutopilot_blockedByMinQuorum(t *testing.T) {
+	s1Config := testConfig()
+	s1Config.Autopilot = testAutopilotConfig()
+	s1Config.Autopilot.MinQuorum = 1
+
+	s2Config := testConfig()
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defer s1.Shutdown()
+	defer s2.Shutdown()
+
+	if _, err := s1.Join([]string{s2Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberCount(t, s1, 2)
+
+	// Force s2 to appear failed. With only 2 members total, s2's failure
+	// leaves the live count at 1, which is not > MinQuorum (1), so
+	// autopilot must refuse to act.
+	if err := s2.Shutdown(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberStatus(t, s1, s2Config.NodeName, StatusFailed)
+
+	// Give autopilot several cleanup passes worth of time to (not) act.
+	time.Sleep(s1Config.Autopilot.DeadNodeCleanupInterval * 4)
+
+	testMember(t, s1.Members(), s2Config.NodeName, StatusFailed)
+}
@@ -0,0 +1,181 @@
+package serf
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Config is the configuration for creating a Serf instance.
+type Config struct {
+	// The name of this node. This must be unique in the cluster. If this
+	// is not set, Serf will set it to the hostname of the running machine.
+	NodeName string
+
+	// The role for this node, if any. This is used to differentiate
+	// between perhaps different members of a Serf. For example, you might
+	// have a "load-balancer" role and a "web" role part of the same cluster.
+	// When new nodes are added, the load balancer wants to know (so it
+	// must be part of the cluster), but it doesn't want to add other load
+	// balancers to the rotation, so it checks if the added nodes are "web".
+	Role string
+
+	// EventCh is a channel that receives all the Serf events. The events
+	// are sent on this channel in proper ordering. Care must be taken that
+	// this channel doesn't block, either by processing the events quick
+	// enough or buffering the channel, otherwise it can block state updates
+	// within Serf itself. If no EventCh is specified, no events will be fired,
+	// but point-in-time snapshots of members can still be retrieved by
+	// calling Members on Serf.
+	EventCh chan<- Event
+
+	// BroadcastTimeout is the amount of time to wait for a broadcast
+	// message to be sent to the cluster. Broadcast messages are used for
+	// things like leave messages and force remove messages. If this is not
+	// set, a timeout of 5 seconds will be set.
+	BroadcastTimeout time.Duration
+
+	// The settings below relate to Serf keeping track of recently
+	// failed/left nodes and attempting reconnects.
+	//
+	// ReapInterval is the interval when the reaper runs. If this is not
+	// set (it is zero), it will be set to a reasonable default.
+	//
+	// ReconnectInterval is the interval when we attempt to reconnect
+	// to failed nodes. If this is not set (it is zero), it will be set
+	// to a reasonable default.
+	//
+	// ReconnectTimeout is the amount of time to attempt to reconnect to
+	// a failed node before giving up and considering it completely gone.
+	//
+	// TombstoneTimeout is the amount of time to keep around nodes
+	// that gracefully left as tombstones for syncing state with other
+	// Serf nodes.
+	ReapInterval      time.Duration
+	ReconnectInterval time.Duration
+	ReconnectTimeout  time.Duration
+	TombstoneTimeout  time.Duration
+
+	// LeaveTimeout bounds how long a member may sit in StatusLeaving
+	// without that leave being confirmed (by the corresponding
+	// StatusLeft/StatusFailed transition). If a member is still marked
+	// StatusLeaving after LeaveTimeout has elapsed since the leave
+	// intent was witnessed, Serf assumes the leave intent was stale or
+	// never followed through and resets the member back to StatusAlive.
+	// Zero disables this behavior.
+	LeaveTimeout time.Duration
+
+	// QueueDepthWarning is used to generate warning message if the
+	// number of queued messages to broadcast exceeds this number. This
+	// is to provide the user feedback if events are being triggered
+	// faster than they can be disseminated
+	QueueDepthWarning int
+
+	// RecentIntentBuffer is used to set the size of recent join and leave intent
+	// messages that will be buffered. This is used to guard against
+	// the case where Serf broadcasts an intent that arrives before the
+	// Memberlist event. It is important that this not be too small to avoid
+	// continuous rebroadcasting of dead events.
+	RecentIntentBuffer int
+
+	// EventBuffer is used to control how many events are buffered.
+	// This is used to prevent re-delivery of events to a client. The buffer
+	// must be large enough to handle all "recent" events, since Serf will
+	// not deliver messages that are older than the oldest entry in the buffer.
+	// Thus if a client is generating too many events, it's possible that the
+	// buffer gets overrun and messages are not delivered.
+	EventBuffer int
+
+	// QueryTimeout is the default amount of time Serf's internal query
+	// mechanism waits for replies before a QueryResponse's ResponseCh is
+	// closed. It's used by operations like the keyring management API
+	// that need every live member to have a chance to reply.
+	QueryTimeout time.Duration
+
+	// Autopilot, if set, enables a background goroutine that
+	// automatically removes members that have been failed for too long,
+	// subject to the quorum safety checks in AutopilotConfig. A nil
+	// value (the default) disables autopilot entirely.
+	Autopilot *AutopilotConfig
+
+	// GatewayResolver, if set, allows JoinThroughGateway to be called
+	// with target labels (e.g. datacenter names) instead of literal
+	// gateway addresses; it's consulted to resolve each label to a list
+	// of addresses.
+	GatewayResolver GatewayResolver
+
+	// GatewayListenAddr, if set, starts a control listener at this
+	// address that lets other Serf clusters federate with this one by
+	// calling JoinThroughGateway against it -- letting this node act as
+	// a mesh gateway. A zero value (the default) disables the listener
+	// entirely, so a node that never federates doesn't open a port for it.
+	GatewayListenAddr string
+
+	// GatewaySyncInterval controls how often each side of a gateway
+	// session (started by either JoinThroughGateway or an accepted
+	// GatewayListenAddr connection) resends its local membership
+	// snapshot to its peer. If zero, a default of 200ms is used.
+	GatewaySyncInterval time.Duration
+
+	// DisableCoordinates controls if Serf will maintain an estimate of
+	// this node's network coordinate internally. A network coordinate
+	// is useful for estimating the network distance (i.e. round trip
+	// time) between two nodes. While coordinates are maintained, each
+	// node periodically gossips its own coordinate (see coordinateLoop);
+	// ping messages carry no extra payload.
+	DisableCoordinates bool
+
+	// MemberlistConfig is the memberlist configuration that Serf will
+	// use to do the underlying membership management and gossip. Some
+	// fields in the MemberlistConfig will be overwritten by Serf no
+	// matter what:
+	//
+	//   * Name - This will always be set to the same as the NodeName
+	//     in this configuration.
+	//
+	//   * Events - Serf uses a custom event delegate.
+	//
+	//   * Delegate - Serf uses a custom delegate.
+	//
+	//   * Ping - Serf uses a custom ping delegate to feed probe RTTs
+	//     into its network coordinate estimate, unless DisableCoordinates
+	//     is set.
+	//
+	MemberlistConfig *memberlist.Config
+
+	// LogOutput is the location to write logs to. If this is not set,
+	// logs will go to stderr.
+	LogOutput io.Writer
+}
+
+// QueryParams returns the default parameters to use for an internal Serf
+// query, such as the ones used by the keyring management API.
+func (c *Config) QueryParams() *QueryParam {
+	return &QueryParam{Timeout: c.QueryTimeout}
+}
+
+// DefaultConfig returns a Config struct that contains reasonable defaults
+// for most of the configurations.
+func DefaultConfig() *Config {
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+
+	return &Config{
+		NodeName:           hostname,
+		BroadcastTimeout:   5 * time.Second,
+		EventBuffer:        512,
+		LogOutput:          os.Stderr,
+		ReapInterval:       15 * time.Second,
+		RecentIntentBuffer: 128,
+		ReconnectInterval:  30 * time.Second,
+		ReconnectTimeout:   24 * time.Hour,
+		QueueDepthWarning:  128,
+		TombstoneTimeout:   24 * time.Hour,
+		QueryTimeout:       2 * time.Second,
+		MemberlistConfig:   memberlist.DefaultLANConfig(),
+	}
+}
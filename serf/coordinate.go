@@ -0,0 +1,173 @@
+package serf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tristanmorgan/serf/coordinate"
+)
+
+// messageCoordinate is periodically gossiped so that members learn each
+// other's network coordinate without needing to probe every peer
+// directly; only a node's own coordinate is broadcast, and peers combine
+// it with their own probe-derived RTTs to learn theirs.
+type messageCoordinate struct {
+	Node  string
+	Coord *coordinate.Coordinate
+}
+
+// messageCoordinateType is the message type tag for messageCoordinate.
+// Like the other extensions in this package, it's placed above the core
+// protocol's message types to avoid future collisions.
+const messageCoordinateType messageType = 52
+
+// coordClient tracks this node's own Vivaldi coordinate and a cache of
+// every other member's most recently gossiped coordinate.
+type coordClient struct {
+	client *coordinate.Client
+
+	l      sync.RWMutex
+	cached map[string]*coordinate.Coordinate
+}
+
+func newCoordClient() (*coordClient, error) {
+	client, err := coordinate.NewClient(coordinate.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coordinate client: %w", err)
+	}
+
+	return &coordClient{
+		client: client,
+		cached: make(map[string]*coordinate.Coordinate),
+	}, nil
+}
+
+// updateFromProbe feeds a round-trip time measured against node into this
+// node's Vivaldi coordinate, using node's last known coordinate.
+func (c *coordClient) updateFromProbe(node string, rtt time.Duration) {
+	c.l.RLock()
+	other, ok := c.cached[node]
+	c.l.RUnlock()
+	if !ok {
+		return
+	}
+
+	if _, err := c.client.Update(node, other, rtt); err != nil {
+		return
+	}
+}
+
+func (c *coordClient) setCached(node string, coord *coordinate.Coordinate) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.cached[node] = coord
+}
+
+func (c *coordClient) getCached(node string) (*coordinate.Coordinate, bool) {
+	c.l.RLock()
+	defer c.l.RUnlock()
+	coord, ok := c.cached[node]
+	return coord, ok
+}
+
+// GetCoordinate returns this node's network coordinate, or an error if
+// DisableCoordinates is set.
+func (s *Serf) GetCoordinate() (*coordinate.Coordinate, error) {
+	if s.config.DisableCoordinates {
+		return nil, fmt.Errorf("coordinates are disabled")
+	}
+	return s.coordClient.client.GetCoordinate(), nil
+}
+
+// GetCachedCoordinate returns the last known coordinate gossiped by the
+// given node, if we have one, without performing any direct probe.
+func (s *Serf) GetCachedCoordinate(node string) (*coordinate.Coordinate, bool) {
+	if s.config.DisableCoordinates {
+		return nil, false
+	}
+	return s.coordClient.getCached(node)
+}
+
+// RTTEstimate returns the estimated round-trip time between members a and
+// b, derived purely from coordinates: this node's own current coordinate
+// for the local node, and the last gossiped coordinate cached for any
+// other member. Neither member needs to be the local node.
+func (s *Serf) RTTEstimate(a, b string) (time.Duration, error) {
+	if s.config.DisableCoordinates {
+		return 0, fmt.Errorf("coordinates are disabled")
+	}
+
+	var coordA, coordB *coordinate.Coordinate
+	if a == s.config.NodeName {
+		coordA = s.coordClient.client.GetCoordinate()
+	} else if c, ok := s.coordClient.getCached(a); ok {
+		coordA = c
+	} else {
+		return 0, fmt.Errorf("no coordinate known for %q", a)
+	}
+
+	if b == s.config.NodeName {
+		coordB = s.coordClient.client.GetCoordinate()
+	} else if c, ok := s.coordClient.getCached(b); ok {
+		coordB = c
+	} else {
+		return 0, fmt.Errorf("no coordinate known for %q", b)
+	}
+
+	dist := coordA.DistanceTo(coordB)
+	return time.Duration(dist * float64(time.Second)), nil
+}
+
+// handleNodeCoordinate processes a messageCoordinate received from the
+// network, updating our cache of that node's coordinate.
+func (s *Serf) handleNodeCoordinate(c *messageCoordinate) {
+	if s.config.DisableCoordinates {
+		return
+	}
+	s.coordClient.setCached(c.Node, c.Coord)
+}
+
+// broadcastCoordinate gossips this node's current coordinate to the rest
+// of the cluster. It's called periodically by a background goroutine
+// started alongside the reaper and autopilot.
+func (s *Serf) broadcastCoordinate() {
+	if s.config.DisableCoordinates {
+		return
+	}
+
+	msg := &messageCoordinate{
+		Node:  s.config.NodeName,
+		Coord: s.coordClient.client.GetCoordinate(),
+	}
+
+	raw, err := encodeMessage(messageCoordinateType, msg)
+	if err != nil {
+		s.logger.Printf("[ERR] serf: failed to encode coordinate: %s", err)
+		return
+	}
+
+	if err := s.broadcast(raw, nil); err != nil {
+		s.logger.Printf("[ERR] serf: failed to broadcast coordinate: %s", err)
+	}
+}
+
+// coordinateLoop periodically broadcasts this node's coordinate until
+// shutdownCh is closed.
+func (s *Serf) coordinateLoop(interval time.Duration, shutdownCh <-chan struct{}) {
+	if s.config.DisableCoordinates {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.broadcastCoordinate()
+		case <-shutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package serf
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/tristanmorgan/serf/serf/testutil"
+)
+
+// simulateProbes drives enough synthetic RTT observations through each
+// node's coordClient, in both directions, for their Vivaldi coordinates
+// to converge on the given delays. It stands in for a wrapping transport
+// that injects artificial latency: rather than actually delaying packets,
+// it feeds the delay directly to the same update path a real probe
+// round-trip would use.
+func simulateProbes(t *testing.T, nodes []*Serf, delay map[[2]string]time.Duration) {
+	t.Helper()
+
+	// Coordinates are exchanged by gossip, so every node first needs to
+	// know about every other node's (starting, origin) coordinate before
+	// updateFromProbe has anything to compare against.
+	for _, a := range nodes {
+		coord, err := a.GetCoordinate()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for _, b := range nodes {
+			if a == b {
+				continue
+			}
+			b.handleNodeCoordinate(&messageCoordinate{Node: a.config.NodeName, Coord: coord})
+		}
+	}
+
+	for round := 0; round < 200; round++ {
+		for _, a := range nodes {
+			for _, b := range nodes {
+				if a == b {
+					continue
+				}
+				rtt, ok := delay[[2]string{a.config.NodeName, b.config.NodeName}]
+				if !ok {
+					continue
+				}
+
+				a.coordClient.updateFromProbe(b.config.NodeName, rtt)
+
+				coord, err := a.GetCoordinate()
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				b.handleNodeCoordinate(&messageCoordinate{Node: a.config.NodeName, Coord: coord})
+			}
+		}
+	}
+}
+
+func TestSerf_coordinates(t *testing.T) {
+	s1Config := testConfig()
+	s2Config := testConfig()
+	s3Config := testConfig()
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s2.Shutdown()
+
+	s3, err := Create(s3Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s3.Shutdown()
+
+	if _, err := s1.Join([]string{s2Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s1.Join([]string{s3Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberCount(t, s1, 3)
+
+	delay := map[[2]string]time.Duration{
+		{s1Config.NodeName, s2Config.NodeName}: 30 * time.Millisecond,
+		{s2Config.NodeName, s1Config.NodeName}: 30 * time.Millisecond,
+		{s1Config.NodeName, s3Config.NodeName}: 80 * time.Millisecond,
+		{s3Config.NodeName, s1Config.NodeName}: 80 * time.Millisecond,
+		{s2Config.NodeName, s3Config.NodeName}: 50 * time.Millisecond,
+		{s3Config.NodeName, s2Config.NodeName}: 50 * time.Millisecond,
+	}
+
+	simulateProbes(t, []*Serf{s1, s2, s3}, delay)
+
+	check := func(a, b *Serf, aName, bName string, want time.Duration) {
+		got, err := a.RTTEstimate(aName, bName)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		tolerance := 0.25 * float64(want)
+		if math.Abs(float64(got-want)) > tolerance {
+			t.Fatalf("estimate %s between %s and %s too far from injected %s", got, aName, bName, want)
+		}
+	}
+
+	check(s1, s2, s1Config.NodeName, s2Config.NodeName, 30*time.Millisecond)
+	check(s1, s3, s1Config.NodeName, s3Config.NodeName, 80*time.Millisecond)
+	check(s2, s3, s2Config.NodeName, s3Config.NodeName, 50*time.Millisecond)
+}
+
+// delayTransport wraps a real memberlist.NetTransport to inject an
+// artificial one-way send delay in front of each outbound packet, keyed
+// by destination address. It stands in for the "wrapping transport" a
+// real network would need for TestSerf_coordinates_realProbes to drive
+// actual memberlist probes -- and therefore pingDelegate.NotifyPingComplete
+// and coordinateLoop's real messageCoordinate gossip -- with realistic,
+// differentiated latency, instead of feeding samples directly into
+// coordClient the way simulateProbes does.
+type delayTransport struct {
+	*memberlist.NetTransport
+	delays map[string]time.Duration
+}
+
+func newDelayTransport(t *testing.T, bindAddr string) *delayTransport {
+	t.Helper()
+
+	nt, err := memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+		BindAddrs: []string{bindAddr},
+		BindPort:  0,
+		Logger:    log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return &delayTransport{NetTransport: nt, delays: make(map[string]time.Duration)}
+}
+
+// WriteToAddress is the method memberlist actually calls to send packets
+// (WriteTo only exists to satisfy the plain Transport interface, and just
+// forwards to this); overriding it here is what lets us delay real probe
+// traffic instead of silently missing it.
+func (d *delayTransport) WriteToAddress(b []byte, a memberlist.Address) (time.Time, error) {
+	if delay := d.delays[a.Addr]; delay > 0 {
+		time.Sleep(delay)
+	}
+	return d.NetTransport.WriteToAddress(b, a)
+}
+
+func (d *delayTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	return d.WriteToAddress(b, memberlist.Address{Addr: addr})
+}
+
+// TestSerf_coordinates_realProbes exercises the coordinate system's real
+// wiring end to end: each node's memberlist probes travel over an actual
+// UDP transport wrapped to add artificial per-peer latency, so the RTTs
+// fed into coordClient via pingDelegate.NotifyPingComplete, and the
+// messageCoordinate gossip broadcastCoordinate/coordinateLoop send over
+// the wire, are the real thing -- not synthetic samples injected directly
+// into the client the way simulateProbes does above.
+func TestSerf_coordinates_realProbes(t *testing.T) {
+	addrs := make([]string, 3)
+	transports := make([]*delayTransport, 3)
+	for i := range addrs {
+		addrs[i] = getBindAddr().String()
+		transports[i] = newDelayTransport(t, addrs[i])
+	}
+
+	fullAddr := func(i int) string {
+		return fmt.Sprintf("%s:%d", addrs[i], transports[i].GetAutoBindPort())
+	}
+
+	// One-way delays are additive across a round trip, so each pair's two
+	// directions are set to half the RTT we want memberlist's probes --
+	// and so the coordinate system -- to observe: 30ms between nodes 0
+	// and 1, 80ms between 0 and 2, 50ms between 1 and 2.
+	transports[0].delays[fullAddr(1)] = 15 * time.Millisecond
+	transports[0].delays[fullAddr(2)] = 40 * time.Millisecond
+	transports[1].delays[fullAddr(0)] = 15 * time.Millisecond
+	transports[1].delays[fullAddr(2)] = 25 * time.Millisecond
+	transports[2].delays[fullAddr(0)] = 40 * time.Millisecond
+	transports[2].delays[fullAddr(1)] = 25 * time.Millisecond
+
+	names := make([]string, 3)
+	nodes := make([]*Serf, 3)
+	for i := range nodes {
+		c := testConfig()
+		c.MemberlistConfig.BindAddr = addrs[i]
+		c.MemberlistConfig.Transport = transports[i]
+		// testConfig's probe timing is tuned for near-instant loopback
+		// round trips; the injected delays here go up to 80ms round
+		// trip, so probes need enough headroom to actually complete
+		// instead of timing out and flapping the cluster.
+		c.MemberlistConfig.ProbeInterval = 200 * time.Millisecond
+		c.MemberlistConfig.ProbeTimeout = 150 * time.Millisecond
+		c.MemberlistConfig.SuspicionMult = 4
+		c.NodeName = fmt.Sprintf("RealProbeNode%d", i)
+		names[i] = c.NodeName
+
+		s, err := Create(c)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer s.Shutdown()
+		nodes[i] = s
+	}
+
+	if _, err := nodes[0].Join([]string{fullAddr(1)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := nodes[0].Join([]string{fullAddr(2)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, n := range nodes {
+		waitForMemberCount(t, n, 3)
+	}
+
+	check := func(i, j int, want time.Duration) (bool, error) {
+		got, err := nodes[i].RTTEstimate(names[i], names[j])
+		if err != nil {
+			return false, err
+		}
+
+		tolerance := 0.5 * float64(want)
+		if math.Abs(float64(got-want)) > tolerance {
+			return false, fmt.Errorf("estimate %s between %s and %s too far from expected %s", got, names[i], names[j], want)
+		}
+		return true, nil
+	}
+
+	err := testutil.WaitForResult(func() (bool, error) {
+		if ok, err := check(0, 1, 30*time.Millisecond); !ok {
+			return false, err
+		}
+		if ok, err := check(0, 2, 80*time.Millisecond); !ok {
+			return false, err
+		}
+		if ok, err := check(1, 2, 50*time.Millisecond); !ok {
+			return false, err
+		}
+		return true, nil
+	}, 20*time.Second)
+	if err != nil {
+		t.Fatalf("coordinates never converged on real-probe delays via gossip: %s", err)
+	}
+}
+
+func TestSerf_coordinatesDisabled(t *testing.T) {
+	s1Config := testConfig()
+	s1Config.DisableCoordinates = true
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s1.Shutdown()
+
+	if _, err := s1.GetCoordinate(); err == nil {
+		t.Fatal("expected error, coordinates should be disabled")
+	}
+}
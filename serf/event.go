@@ -0,0 +1,87 @@
+package serf
+
+// EventType are all the types of events that may occur and be sent
+// along the Serf channel.
+type EventType int
+
+const (
+	EventMemberJoin EventType = iota
+	EventMemberLeave
+	EventMemberFailed
+	EventMemberUpdate
+	EventMemberReap
+	EventUserEvent
+	EventQuery
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventMemberJoin:
+		return "member-join"
+	case EventMemberLeave:
+		return "member-leave"
+	case EventMemberFailed:
+		return "member-failed"
+	case EventMemberUpdate:
+		return "member-update"
+	case EventMemberReap:
+		return "member-reap"
+	case EventUserEvent:
+		return "user"
+	case EventQuery:
+		return "query"
+	default:
+		panic("unknown event type")
+	}
+}
+
+// Event is a generic interface for exposing Serf events. Clients will
+// usually need to use a type switch to get to a more useful type.
+type Event interface {
+	EventType() EventType
+	String() string
+}
+
+// MemberEvent is the struct used for member related events. It covers
+// everything from join/leave/failed/update/reap, and is fired when one of
+// those things happens to any member of the cluster.
+type MemberEvent struct {
+	Type    EventType
+	Members []Member
+}
+
+func (m MemberEvent) EventType() EventType {
+	return m.Type
+}
+
+func (m MemberEvent) String() string {
+	switch m.Type {
+	case EventMemberJoin:
+		return "member-join"
+	case EventMemberLeave:
+		return "member-leave"
+	case EventMemberFailed:
+		return "member-failed"
+	case EventMemberUpdate:
+		return "member-update"
+	case EventMemberReap:
+		return "member-reap"
+	default:
+		panic("unknown event type")
+	}
+}
+
+// UserEvent is the struct used for events that are triggered by the user
+// and via the UserEvent method.
+type UserEvent struct {
+	Name    string
+	Payload []byte
+}
+
+func (u UserEvent) EventType() EventType {
+	return EventUserEvent
+}
+
+func (u UserEvent) String() string {
+	return "user-event: " + u.Name
+}
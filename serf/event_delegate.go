@@ -0,0 +1,22 @@
+package serf
+
+import (
+	"github.com/hashicorp/memberlist"
+)
+
+// eventDelegate is the memberlist.EventDelegate implementation that Serf
+// uses to learn about membership changes from the underlying memberlist.
+type eventDelegate struct {
+	serf *Serf
+}
+
+func (e *eventDelegate) NotifyJoin(n *memberlist.Node) {
+	e.serf.handleNodeJoin(n)
+}
+
+func (e *eventDelegate) NotifyLeave(n *memberlist.Node) {
+	e.serf.handleNodeLeave(n)
+}
+
+func (e *eventDelegate) NotifyUpdate(n *memberlist.Node) {
+}
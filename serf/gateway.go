@@ -0,0 +1,295 @@
+package serf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// GatewayResolver resolves the addresses of mesh gateways that can relay
+// memberlist traffic to a remote datacenter/target label. It mirrors the
+// WAN federation pattern used by other HashiCorp products: rather than
+// dialing a remote cluster directly, a node first contacts a gateway that
+// forwards traffic on to the real members.
+type GatewayResolver interface {
+	// ResolveGateway returns the addresses of gateways that can relay to
+	// the given target (typically a datacenter name).
+	ResolveGateway(target string) ([]string, error)
+}
+
+// messageRelayJoin is exchanged over a gateway session (see
+// runGatewaySession) to tell the other side about one member of the
+// sender's own cluster. It deliberately carries only join information --
+// there's no corresponding leave/fail message, since federation is scoped
+// to "let two clusters discover each other's membership", not to mirror
+// every status transition across the gateway.
+type messageRelayJoin struct {
+	LTime LamportTime
+	Node  string
+	Addr  net.IP
+	Role  string
+}
+
+// messageRelayJoinType is the message type tag for messageRelayJoin. It's
+// deliberately placed above the core protocol's message types so it can't
+// collide with a messageType the core protocol defines later.
+const messageRelayJoinType messageType = 50
+
+// gatewayFrameMaxSize bounds how large a single messageRelayJoin frame on
+// a gateway session may be, so a misbehaving or corrupt peer can't make
+// readGatewayFrame allocate an unbounded buffer.
+const gatewayFrameMaxSize = 1 << 20
+
+// defaultGatewaySyncInterval is used when Config.GatewaySyncInterval is
+// zero.
+const defaultGatewaySyncInterval = 200 * time.Millisecond
+
+// JoinThroughGateway federates with a remote cluster by dialing one of the
+// given gateway addresses and exchanging membership over a dedicated
+// control connection, rather than joining the gateway's own memberlist
+// cluster directly. This keeps the two clusters' gossip and failure
+// detection fully isolated: each side only ever learns *about* the other's
+// members (as synthetic, relayed memberState entries -- see
+// handleNodeRelayJoin), never becomes a memberlist peer of them. targetDC
+// identifies, from the gateway's point of view, which remote
+// datacenter/cluster this node is trying to reach (currently unused beyond
+// logging, since a gateway relays its whole cluster's membership
+// regardless of target -- it exists so resolver-based addressing has
+// somewhere to attach future per-target filtering).
+//
+// If Config.GatewayResolver is set, gateways is treated as a list of
+// labels to resolve rather than literal addresses.
+func (s *Serf) JoinThroughGateway(gateways []string, targetDC string) (int, error) {
+	addrs := gateways
+	if s.config.GatewayResolver != nil {
+		resolved := make([]string, 0, len(gateways))
+		for _, label := range gateways {
+			gwAddrs, err := s.config.GatewayResolver.ResolveGateway(label)
+			if err != nil {
+				return 0, fmt.Errorf("failed to resolve gateway %q: %w", label, err)
+			}
+			resolved = append(resolved, gwAddrs...)
+		}
+		addrs = resolved
+	}
+
+	if len(addrs) == 0 {
+		return 0, fmt.Errorf("no gateway addresses to join through")
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		s.logger.Printf("[INFO] serf: joined gateway %s for target %q", addr, targetDC)
+		go s.runGatewaySession(conn)
+		return 1, nil
+	}
+
+	return 0, fmt.Errorf("failed to reach any gateway address: %w", lastErr)
+}
+
+// GatewayAddr returns the address this node's gateway control listener is
+// bound to, or "" if Config.GatewayListenAddr was not set. It's mainly
+// useful in tests, where GatewayListenAddr is usually given with a ":0"
+// port so the kernel picks one.
+func (s *Serf) GatewayAddr() string {
+	if s.gatewayListener == nil {
+		return ""
+	}
+	return s.gatewayListener.Addr().String()
+}
+
+// acceptGatewayConns accepts incoming gateway control connections on ln
+// until it's closed by Shutdown, spawning a session for each one. It's
+// started by Create when Config.GatewayListenAddr is set.
+func (s *Serf) acceptGatewayConns(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// Only expected once Shutdown closes the listener.
+			return
+		}
+
+		s.logger.Printf("[INFO] serf: accepted gateway connection from %s", conn.RemoteAddr())
+		go s.runGatewaySession(conn)
+	}
+}
+
+// runGatewaySession drives one side of a gateway control connection, used
+// both by JoinThroughGateway (the dialing side) and acceptGatewayConns
+// (the accepting side) -- the protocol is symmetric, so both run the same
+// write/read pump pair. It periodically sends this cluster's own native
+// membership to the peer, and applies whatever membership the peer sends
+// back, relaying anything new into this node's own cluster via its normal
+// broadcast queue. It returns once the connection errors or this Serf
+// instance shuts down.
+func (s *Serf) runGatewaySession(conn net.Conn) {
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.shutdownCh:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	go s.gatewaySessionWrite(conn, done)
+	s.gatewaySessionRead(conn)
+}
+
+// gatewaySessionWrite periodically writes this cluster's native membership
+// to conn, until done is closed or a write fails.
+func (s *Serf) gatewaySessionWrite(conn net.Conn, done <-chan struct{}) {
+	interval := s.config.GatewaySyncInterval
+	if interval <= 0 {
+		interval = defaultGatewaySyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, m := range s.nativeMembers() {
+			msg := messageRelayJoin{
+				LTime: s.clock.Time(),
+				Node:  m.Name,
+				Addr:  m.Addr,
+				Role:  m.Role,
+			}
+			if err := writeGatewayFrame(conn, &msg); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// gatewaySessionRead reads messageRelayJoin frames from conn until it
+// errors, applying each one and relaying it into this node's own cluster
+// if it's new.
+func (s *Serf) gatewaySessionRead(conn net.Conn) {
+	for {
+		msg, err := readGatewayFrame(conn)
+		if err != nil {
+			return
+		}
+
+		if msg.Node == s.config.NodeName {
+			// The peer is relaying our own cluster's membership back to
+			// us; nothing to learn from our own entry.
+			continue
+		}
+
+		if s.handleNodeRelayJoin(msg) {
+			raw, err := encodeMessage(messageRelayJoinType, msg)
+			if err != nil {
+				s.logger.Printf("[ERR] serf: failed to encode relayed join for %s: %s", msg.Node, err)
+				continue
+			}
+			if err := s.broadcast(raw, nil); err != nil {
+				s.logger.Printf("[ERR] serf: failed to broadcast relayed join for %s: %s", msg.Node, err)
+			}
+		}
+	}
+}
+
+// writeGatewayFrame writes msg to w as a length-prefixed, encoded
+// messageRelayJoin, so the reader on the other end of a TCP stream knows
+// where one frame ends and the next begins.
+func writeGatewayFrame(w io.Writer, msg *messageRelayJoin) error {
+	raw, err := encodeMessage(messageRelayJoinType, msg)
+	if err != nil {
+		return err
+	}
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(raw)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	_, err = w.Write(raw)
+	return err
+}
+
+// readGatewayFrame reads one length-prefixed messageRelayJoin previously
+// written by writeGatewayFrame from r.
+func readGatewayFrame(r io.Reader) (*messageRelayJoin, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n == 0 || n > gatewayFrameMaxSize {
+		return nil, fmt.Errorf("invalid gateway frame size: %d bytes", n)
+	}
+
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+
+	if messageType(raw[0]) != messageRelayJoinType {
+		return nil, fmt.Errorf("unexpected gateway frame type: %d", raw[0])
+	}
+
+	var msg messageRelayJoin
+	if err := decodeMessage(raw[1:], &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// handleNodeRelayJoin processes a messageRelayJoin received over a gateway
+// session, creating or refreshing a relayed memberState for its node. It
+// follows the same "only new information rebroadcasts" convention as
+// handleNodeJoinIntent: a message no newer than what's already recorded
+// reports false, both so the gossip broadcast of it stops propagating and
+// so repeated resyncs of an already-known relayed member don't requeue a
+// fresh EventMemberJoin on every sync interval.
+func (s *Serf) handleNodeRelayJoin(msg *messageRelayJoin) bool {
+	s.memberLock.Lock()
+	defer s.memberLock.Unlock()
+
+	member, ok := s.members[msg.Node]
+	if ok && msg.LTime <= member.statusLTime {
+		return false
+	}
+
+	if !ok {
+		member = &memberState{Member: Member{Name: msg.Node}}
+		s.members[msg.Node] = member
+	}
+
+	member.Addr = msg.Addr
+	member.Role = msg.Role
+	member.Status = StatusAlive
+	member.StatusTime = time.Now()
+	member.statusLTime = msg.LTime
+	member.relayed = true
+
+	s.logger.Printf("[INFO] serf: EventMemberJoin (relayed): %s %s", member.Name, member.Addr)
+	if s.config.EventCh != nil {
+		s.config.EventCh <- MemberEvent{
+			Type:    EventMemberJoin,
+			Members: []Member{member.Member},
+		}
+	}
+	return true
+}
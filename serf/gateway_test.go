@@ -0,0 +1,150 @@
+package serf
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tristanmorgan/serf/serf/testutil"
+)
+
+// TestSerf_joinThroughGateway builds two fully independent Serf clusters --
+// dc1 (dc1a joined with dc1b) and dc2 (dc2a joined with dc2b) -- with gw
+// acting as a normal member of dc1 that also exposes a gateway listener.
+// dc2a then federates with dc1 by calling JoinThroughGateway against gw,
+// rather than joining it. The test asserts both that membership is learned
+// across the gateway (Serf-level Members()/events) and that the two
+// clusters' underlying memberlist gossip stays fully isolated (dc1's real
+// memberlist never gains a dc2 node as a peer, and vice versa).
+func TestSerf_joinThroughGateway(t *testing.T) {
+	dc1aConfig := testConfig()
+	dc1aEventCh := make(chan Event, 8)
+	dc1aConfig.EventCh = dc1aEventCh
+	dc1a, err := Create(dc1aConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dc1a.Shutdown()
+
+	dc1bConfig := testConfig()
+	dc1b, err := Create(dc1bConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dc1b.Shutdown()
+
+	if _, err := dc1a.Join([]string{dc1bConfig.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	waitForMemberCount(t, dc1a, 2)
+
+	gwConfig := testConfig()
+	gwConfig.GatewayListenAddr = gwConfig.MemberlistConfig.BindAddr + ":0"
+	gw, err := Create(gwConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer gw.Shutdown()
+
+	if _, err := gw.Join([]string{dc1aConfig.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	waitForMemberCount(t, gw, 3)
+	waitForMemberCount(t, dc1a, 3)
+
+	dc2aConfig := testConfig()
+	dc2aEventCh := make(chan Event, 8)
+	dc2aConfig.EventCh = dc2aEventCh
+	dc2a, err := Create(dc2aConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dc2a.Shutdown()
+
+	dc2bConfig := testConfig()
+	dc2b, err := Create(dc2bConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dc2b.Shutdown()
+
+	if _, err := dc2a.Join([]string{dc2bConfig.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	waitForMemberCount(t, dc2a, 2)
+
+	if _, err := dc2a.JoinThroughGateway([]string{gw.GatewayAddr()}, "dc1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Each side should eventually learn about every member of the other
+	// cluster (2 local + 3 relayed, and vice versa), purely via the
+	// gateway session's periodic resync.
+	waitForMemberCount(t, dc1a, 5)
+	waitForMemberCount(t, dc2a, 5)
+
+	assertEvents(t, dc1aEventCh, dc2aConfig.NodeName, []EventType{EventMemberJoin}, 5*time.Second)
+	assertEvents(t, dc2aEventCh, gwConfig.NodeName, []EventType{EventMemberJoin}, 5*time.Second)
+
+	// The two clusters must never actually merge at the memberlist layer:
+	// dc1's real gossip membership should be exactly {dc1a, dc1b, gw}, no
+	// matter what Serf's relayed-member bookkeeping reports.
+	err = testutil.WaitForResult(func() (bool, error) {
+		for _, n := range dc1a.memberlist.Members() {
+			if n.Name == dc2aConfig.NodeName || n.Name == dc2bConfig.NodeName {
+				return false, fmt.Errorf("dc2 node %s leaked into dc1's real memberlist cluster", n.Name)
+			}
+		}
+		if got := len(dc1a.memberlist.Members()); got != 3 {
+			return false, fmt.Errorf("expected dc1's real memberlist cluster to stay at 3 members, got %d", got)
+		}
+		return true, nil
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range dc2a.memberlist.Members() {
+		if n.Name == dc1aConfig.NodeName || n.Name == dc1bConfig.NodeName || n.Name == gwConfig.NodeName {
+			t.Fatalf("dc1 node %s leaked into dc2's real memberlist cluster", n.Name)
+		}
+	}
+}
+
+func TestSerf_joinThroughGateway_resolver(t *testing.T) {
+	gwConfig := testConfig()
+	gwConfig.GatewayListenAddr = gwConfig.MemberlistConfig.BindAddr + ":0"
+	gw, err := Create(gwConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer gw.Shutdown()
+
+	resolver := &staticGatewayResolver{
+		addrs: map[string][]string{
+			"dc2": {gw.GatewayAddr()},
+		},
+	}
+
+	dc1Config := testConfig()
+	dc1Config.GatewayResolver = resolver
+	dc1, err := Create(dc1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dc1.Shutdown()
+
+	if _, err := dc1.JoinThroughGateway([]string{"dc2"}, "dc2"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberStatus(t, dc1, gwConfig.NodeName, StatusAlive)
+}
+
+type staticGatewayResolver struct {
+	addrs map[string][]string
+}
+
+func (s *staticGatewayResolver) ResolveGateway(target string) ([]string, error) {
+	return s.addrs[target], nil
+}
@@ -0,0 +1,251 @@
+package serf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Internal query names used to gossip keyring operations to every member.
+// These piggyback on Serf's existing internal query mechanism rather than
+// introducing a new message/broadcast path.
+const (
+	keyQueryInstall = "_serf_key_install"
+	keyQueryUse     = "_serf_key_use"
+	keyQueryRemove  = "_serf_key_remove"
+	keyQueryList    = "_serf_key_list"
+)
+
+// messageKeyRequest is the payload gossiped to every member for a keyring
+// operation, via Serf's internal query mechanism. List requests carry no
+// key. It's JSON-encoded rather than using encodeMessage/messageType,
+// since it travels as a Query payload rather than as a top-level gossip
+// message.
+type messageKeyRequest struct {
+	Key []byte
+}
+
+func init() {
+	handler := (*Serf).handleKeyQuery
+	internalQueryHandlers[keyQueryInstall] = handler
+	internalQueryHandlers[keyQueryUse] = handler
+	internalQueryHandlers[keyQueryRemove] = handler
+	internalQueryHandlers[keyQueryList] = handler
+}
+
+// nodeKeyResponse is what each member replies with after applying (or
+// merely reporting, for list requests) a keyring operation locally.
+type nodeKeyResponse struct {
+	Result  bool
+	Message string
+	Keys    [][]byte
+}
+
+// KeyResponse is returned by Serf's keyring management methods. It
+// aggregates the result of a keyring operation across every member that
+// responded.
+type KeyResponse struct {
+	// Messages maps node name to an error message, for any node that
+	// failed to apply the operation.
+	Messages map[string]string
+
+	// NumNodes is the number of known members the request was sent to.
+	NumNodes int
+
+	// NumResp is the number of members that replied before the query
+	// finished.
+	NumResp int
+
+	// NumErr is the number of members that replied with a failure.
+	NumErr int
+
+	// Keys maps a key (base64-free, raw bytes as a string) to the
+	// number of members who reported holding it. It's only populated
+	// by ListKeys, and by the post-operation state other operations
+	// report back.
+	Keys map[string]int
+}
+
+func newKeyResponse() *KeyResponse {
+	return &KeyResponse{
+		Messages: make(map[string]string),
+		Keys:     make(map[string]int),
+	}
+}
+
+// InstallKey gossips a new encryption key to every member, adding it to
+// each member's keyring alongside whatever primary key is already in use.
+// It does not change which key is used to encrypt outgoing gossip. It is
+// idempotent: installing a key that's already present on a member is not
+// treated as an error.
+func (s *Serf) InstallKey(key []byte) (*KeyResponse, error) {
+	return s.handleKeyRequest(keyQueryInstall, key)
+}
+
+// UseKey changes the key used to encrypt outgoing gossip to key, which
+// must already be installed on every member. If any member does not have
+// the key installed, the rotation is aborted cluster-wide and an error is
+// returned, since a partial rotation would partition the cluster. Members
+// are checked with a key listing first; a member that does not reply to
+// it is not counted.
+func (s *Serf) UseKey(key []byte) (*KeyResponse, error) {
+	list, err := s.handleKeyRequest(keyQueryList, nil)
+	if err != nil {
+		return list, err
+	}
+	if missing := list.NumResp - list.Keys[string(key)]; missing > 0 {
+		return list, fmt.Errorf("%d/%d members do not have the key installed; "+
+			"primary key unchanged", missing, list.NumResp)
+	}
+
+	resp, err := s.handleKeyRequest(keyQueryUse, key)
+	if err != nil {
+		return resp, err
+	}
+	if resp.NumErr > 0 {
+		return resp, fmt.Errorf("%d/%d members failed to switch to the new key; "+
+			"primary key unchanged on those nodes", resp.NumErr, resp.NumNodes)
+	}
+	return resp, nil
+}
+
+// RemoveKey gossips the removal of key from every member's keyring. It
+// refuses to remove whichever key this node currently uses as its
+// primary, since that would leave the local node unable to encrypt
+// outgoing gossip.
+func (s *Serf) RemoveKey(key []byte) (*KeyResponse, error) {
+	keyring := s.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		return nil, fmt.Errorf("keyring is not enabled on this node")
+	}
+	if primary := keyring.GetPrimaryKey(); primary != nil && bytes.Equal(primary, key) {
+		return nil, fmt.Errorf("removing the primary key is not allowed")
+	}
+	return s.handleKeyRequest(keyQueryRemove, key)
+}
+
+// ListKeys polls every member for the set of keys currently in its
+// keyring, and returns how many members hold each one. A healthy,
+// fully-rotated cluster reports every member holding the same keys.
+func (s *Serf) ListKeys() (*KeyResponse, error) {
+	return s.handleKeyRequest(keyQueryList, nil)
+}
+
+// keyRequestAttempts bounds how many times handleKeyRequest re-sends its
+// query if some members haven't replied yet. The query itself is
+// best-effort gossip, so a straggler that misses one round usually
+// catches the next.
+const keyRequestAttempts = 3
+
+// handleKeyRequest gossips a keyring operation to the cluster via Serf's
+// internal query mechanism and aggregates the per-node replies. It
+// retries the query, without re-counting a node that already replied,
+// until every known member has responded or it runs out of attempts.
+func (s *Serf) handleKeyRequest(queryName string, key []byte) (*KeyResponse, error) {
+	resp := newKeyResponse()
+
+	raw, err := json.Marshal(&messageKeyRequest{Key: key})
+	if err != nil {
+		return resp, fmt.Errorf("failed to encode key request: %w", err)
+	}
+
+	seen := make(map[string]bool)
+
+	for attempt := 0; attempt < keyRequestAttempts; attempt++ {
+		qResp, err := s.Query(queryName, raw, s.config.QueryParams())
+		if err != nil {
+			return resp, fmt.Errorf("failed to start key query: %w", err)
+		}
+
+		resp.NumNodes = qResp.NumNodes()
+
+		for r := range qResp.ResponseCh() {
+			if seen[r.From] {
+				continue
+			}
+			seen[r.From] = true
+			resp.NumResp++
+
+			var nodeResp nodeKeyResponse
+			if err := json.Unmarshal(r.Payload, &nodeResp); err != nil {
+				resp.NumErr++
+				resp.Messages[r.From] = fmt.Sprintf("failed to decode response: %s", err)
+				continue
+			}
+
+			if !nodeResp.Result {
+				resp.NumErr++
+				resp.Messages[r.From] = nodeResp.Message
+			}
+
+			for _, k := range nodeResp.Keys {
+				resp.Keys[string(k)]++
+			}
+		}
+
+		if resp.NumResp >= resp.NumNodes {
+			break
+		}
+	}
+
+	return resp, nil
+}
+
+// handleKeyQuery is invoked locally on every member when one of the
+// keyring internal queries arrives. It applies the requested keyring
+// mutation (or gathers the current keys, for a list query) and responds
+// with a nodeKeyResponse.
+func (s *Serf) handleKeyQuery(q *Query) {
+	var req messageKeyRequest
+	if len(q.Payload) > 0 {
+		if err := json.Unmarshal(q.Payload, &req); err != nil {
+			s.respondKeyQuery(q, false, fmt.Sprintf("failed to decode key request: %s", err))
+			return
+		}
+	}
+
+	keyring := s.config.MemberlistConfig.Keyring
+	if keyring == nil {
+		s.respondKeyQuery(q, false, "keyring is not enabled on this node")
+		return
+	}
+
+	var err error
+	switch q.Name {
+	case keyQueryInstall:
+		err = keyring.AddKey(req.Key)
+	case keyQueryUse:
+		err = keyring.UseKey(req.Key)
+	case keyQueryRemove:
+		err = keyring.RemoveKey(req.Key)
+	case keyQueryList:
+		// no mutation, just report current keys below
+	default:
+		err = fmt.Errorf("unknown key query %q", q.Name)
+	}
+
+	if err != nil {
+		s.respondKeyQuery(q, false, err.Error())
+		return
+	}
+
+	s.respondKeyQueryWithKeys(q, true, "", keyring.GetKeys())
+}
+
+func (s *Serf) respondKeyQuery(q *Query, ok bool, message string) {
+	s.respondKeyQueryWithKeys(q, ok, message, nil)
+}
+
+func (s *Serf) respondKeyQueryWithKeys(q *Query, ok bool, message string, keys [][]byte) {
+	resp := nodeKeyResponse{Result: ok, Message: message, Keys: keys}
+
+	raw, err := json.Marshal(&resp)
+	if err != nil {
+		s.logger.Printf("[ERR] serf: failed to encode key response: %s", err)
+		return
+	}
+
+	if err := q.Respond(raw); err != nil {
+		s.logger.Printf("[ERR] serf: failed to respond to key query: %s", err)
+	}
+}
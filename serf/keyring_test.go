@@ -0,0 +1,126 @@
+package serf
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tristanmorgan/serf/serf/testutil"
+)
+
+func testConfigWithKey(key []byte) *Config {
+	config := testConfig()
+	config.MemberlistConfig.SecretKey = key
+	return config
+}
+
+func TestSerf_keyringRotation(t *testing.T) {
+	initialKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("abcdef0123456789abcdef0123456789")
+
+	s1Config := testConfigWithKey(initialKey)
+	s2Config := testConfigWithKey(initialKey)
+	s3Config := testConfigWithKey(initialKey)
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s2.Shutdown()
+
+	s3, err := Create(s3Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s3.Shutdown()
+
+	if _, err := s1.Join([]string{s2Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s1.Join([]string{s3Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberCount(t, s1, 3)
+
+	// Install the new key everywhere, but keep gossiping with the old one.
+	installResp, err := s1.InstallKey(newKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if installResp.NumErr != 0 {
+		t.Fatalf("expected no errors installing key, got %d: %v", installResp.NumErr, installResp.Messages)
+	}
+
+	// Switch every member over to using the new key as primary.
+	useResp, err := s1.UseKey(newKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if useResp.NumErr != 0 {
+		t.Fatalf("expected no errors using key, got %d: %v", useResp.NumErr, useResp.Messages)
+	}
+
+	// Now that nobody relies on it, remove the old key everywhere.
+	removeResp, err := s1.RemoveKey(initialKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if removeResp.NumErr != 0 {
+		t.Fatalf("expected no errors removing key, got %d: %v", removeResp.NumErr, removeResp.Messages)
+	}
+
+	// Gossip should continue to work throughout: a late joiner using only
+	// the new key should still be able to join.
+	s4Config := testConfigWithKey(newKey)
+	s4, err := Create(s4Config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s4.Shutdown()
+
+	if _, err := s4.Join([]string{s1Config.MemberlistConfig.BindAddr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	waitForMemberCount(t, s1, 4)
+
+	// The remove query is best-effort gossip; if a straggler misses
+	// every attempt under load, re-issuing it on the next poll catches it
+	// up (removing a key a member no longer holds is not an error).
+	err = testutil.WaitForResult(func() (bool, error) {
+		listResp, err := s1.ListKeys()
+		if err != nil {
+			return false, err
+		}
+		if len(listResp.Keys) == 1 {
+			return true, nil
+		}
+		if _, err := s1.RemoveKey(initialKey); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("expected exactly one key cluster-wide, got %v", listResp.Keys)
+	}, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSerf_removePrimaryKeyDenied(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	s1, err := Create(testConfigWithKey(key))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s1.Shutdown()
+
+	if _, err := s1.RemoveKey(key); err == nil {
+		t.Fatal("expected error removing the primary key")
+	}
+}
@@ -0,0 +1,26 @@
+package serf
+
+import (
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// pingDelegate implements memberlist.PingDelegate so that Serf learns the
+// round-trip time of every successful memberlist probe and feeds it into
+// the local Vivaldi coordinate estimate, without needing a separate
+// out-of-band probing mechanism of its own.
+type pingDelegate struct {
+	serf *Serf
+}
+
+func (p *pingDelegate) AckPayload() []byte {
+	return nil
+}
+
+func (p *pingDelegate) NotifyPingComplete(other *memberlist.Node, rtt time.Duration, payload []byte) {
+	if p.serf.config.DisableCoordinates {
+		return
+	}
+	p.serf.coordClient.updateFromProbe(other.Name, rtt)
+}
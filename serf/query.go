@@ -0,0 +1,261 @@
+package serf
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// messageQuery is broadcast to the cluster to start a new query. Unlike
+// messageUserEvent, replies are not gossiped back: each responding member
+// sends its messageQueryResponse directly to the querying node.
+type messageQuery struct {
+	LTime   LamportTime
+	ID      uint32
+	Node    string // name of the node that started the query
+	Name    string
+	Payload []byte
+}
+
+// messageQueryResponse is sent directly back to the node that started a
+// query by each member that responds to it.
+type messageQueryResponse struct {
+	ID      uint32
+	From    string
+	Payload []byte
+}
+
+const (
+	messageQueryType         messageType = 53
+	messageQueryResponseType messageType = 54
+)
+
+// QueryParam are the optional parameters for a Query.
+type QueryParam struct {
+	// Timeout bounds how long a QueryResponse collects replies before its
+	// ResponseCh is closed. If zero, Config.QueryTimeout is used.
+	Timeout time.Duration
+}
+
+// NodeResponse is a single reply to a Query, from the named node.
+type NodeResponse struct {
+	From    string
+	Payload []byte
+}
+
+// QueryResponse is returned by Serf.Query and streams replies as they
+// arrive. ResponseCh is closed once the query's timeout elapses.
+type QueryResponse struct {
+	numNodes int
+	respCh   chan NodeResponse
+}
+
+// NumNodes returns the number of members the query was sent to.
+func (r *QueryResponse) NumNodes() int {
+	return r.numNodes
+}
+
+// ResponseCh returns the channel replies are delivered on. It is closed
+// once the query's timeout elapses, so it's safe to range over.
+func (r *QueryResponse) ResponseCh() <-chan NodeResponse {
+	return r.respCh
+}
+
+// Query is handed to a query handler (internal handlers like
+// handleKeyQuery, or external consumers via EventCh) for each query
+// received from the network.
+type Query struct {
+	s       *Serf
+	ltime   LamportTime
+	id      uint32
+	from    string
+	Name    string
+	Payload []byte
+}
+
+// Respond sends resp directly back to whichever node started the query.
+func (q *Query) Respond(resp []byte) error {
+	from, ok := q.s.nodeByName(q.from)
+	if !ok {
+		return fmt.Errorf("failed to respond to query: unknown node %q", q.from)
+	}
+
+	msg := messageQueryResponse{
+		ID:      q.id,
+		From:    q.s.config.NodeName,
+		Payload: resp,
+	}
+
+	raw, err := encodeMessage(messageQueryResponseType, &msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode query response: %w", err)
+	}
+
+	return q.s.memberlist.SendBestEffort(from, raw)
+}
+
+func (q Query) EventType() EventType {
+	return EventQuery
+}
+
+func (q Query) String() string {
+	return fmt.Sprintf("query: %s", q.Name)
+}
+
+// Query broadcasts a query with the given name and payload to the
+// cluster, and returns a QueryResponse that streams back replies as they
+// arrive. If params is nil, or params.Timeout is zero, Config.QueryTimeout
+// is used.
+func (s *Serf) Query(name string, payload []byte, params *QueryParam) (*QueryResponse, error) {
+	timeout := s.config.QueryTimeout
+	if params != nil && params.Timeout > 0 {
+		timeout = params.Timeout
+	}
+
+	id := atomic.AddUint32(&s.queryID, 1)
+
+	msg := messageQuery{
+		LTime:   s.queryClock.Time(),
+		ID:      id,
+		Node:    s.config.NodeName,
+		Name:    name,
+		Payload: payload,
+	}
+	s.queryClock.Increment()
+
+	numNodes := len(s.Members())
+	resp := &QueryResponse{
+		numNodes: numNodes,
+		respCh:   make(chan NodeResponse, maxInt(numNodes, 1)),
+	}
+
+	s.queryLock.Lock()
+	s.queries[id] = resp
+	s.queryLock.Unlock()
+
+	// Process our own query locally; we won't receive our own broadcast
+	// back from memberlist.
+	s.invokeQueryHandler(&Query{s: s, ltime: msg.LTime, id: id, from: s.config.NodeName, Name: name, Payload: payload})
+
+	raw, err := encodeMessage(messageQueryType, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query: %w", err)
+	}
+
+	if err := s.broadcast(raw, nil); err != nil {
+		return nil, fmt.Errorf("failed to broadcast query: %w", err)
+	}
+
+	go func() {
+		time.Sleep(timeout)
+		s.queryLock.Lock()
+		delete(s.queries, id)
+		s.queryLock.Unlock()
+		close(resp.respCh)
+	}()
+
+	return resp, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// queryIntent dedups queries that arrive more than once, since the
+// broadcast queue retransmits a query several times to get it around the
+// cluster; without this, a node would invoke its query handler (and send
+// a reply) once per retransmission instead of once per query.
+type queryIntent struct {
+	Node string
+	ID   uint32
+}
+
+// handleQuery processes a messageQuery received from the network,
+// dispatching it to whichever handler is registered for the query's
+// name. It reports whether the message is new to this node: only a
+// query this node hasn't already seen is rebroadcast, the same as
+// handleNodeLeaveIntent/handleNodeJoinIntent. Without that, every
+// receipt -- including a node's Nth copy of a query it has already
+// relayed -- would requeue a fresh, full-transmit-count broadcast,
+// and the query would never stop being regossiped around the cluster.
+func (s *Serf) handleQuery(q *messageQuery) bool {
+	s.queryClock.Witness(q.LTime)
+
+	if q.Node == s.config.NodeName {
+		// We already ran our own query locally, and broadcast it,
+		// when we started it.
+		return false
+	}
+
+	s.queryLock.Lock()
+	for _, seen := range s.recentQueries {
+		if seen.Node == q.Node && seen.ID == q.ID {
+			s.queryLock.Unlock()
+			return false
+		}
+	}
+	s.recentQueries[s.recentQueryIndex] = queryIntent{Node: q.Node, ID: q.ID}
+	s.recentQueryIndex = (s.recentQueryIndex + 1) % len(s.recentQueries)
+	s.queryLock.Unlock()
+
+	s.invokeQueryHandler(&Query{s: s, ltime: q.LTime, id: q.ID, from: q.Node, Name: q.Name, Payload: q.Payload})
+	return true
+}
+
+// invokeQueryHandler dispatches a locally-received query to the internal
+// handler registered for its name, falling back to surfacing it on
+// EventCh for external consumers.
+func (s *Serf) invokeQueryHandler(q *Query) {
+	if handler, ok := internalQueryHandlers[q.Name]; ok {
+		handler(s, q)
+		return
+	}
+
+	if s.config.EventCh != nil {
+		s.config.EventCh <- *q
+	}
+}
+
+// internalQueryHandlers maps reserved query names (such as the keyring
+// management queries) to the Serf method that handles them locally,
+// instead of surfacing them on EventCh.
+var internalQueryHandlers = map[string]func(*Serf, *Query){}
+
+// handleQueryResponse processes a messageQueryResponse received from the
+// network, routing it to the pending QueryResponse it belongs to. The
+// lookup and the send onto respCh happen under queryLock, the same lock
+// Query's timeout goroutine holds while it deletes the entry, and that
+// goroutine only closes respCh after the delete: either this runs first
+// and the send lands before respCh is closed, or the delete runs first,
+// the entry is gone, and this returns without touching the (possibly
+// already closed) channel.
+func (s *Serf) handleQueryResponse(qr *messageQueryResponse) {
+	s.queryLock.Lock()
+	defer s.queryLock.Unlock()
+
+	resp, ok := s.queries[qr.ID]
+	if !ok {
+		// Query already timed out, or this is a duplicate/unknown reply.
+		return
+	}
+
+	select {
+	case resp.respCh <- NodeResponse{From: qr.From, Payload: qr.Payload}:
+	default:
+	}
+}
+
+// nodeByName looks up a live memberlist node by its Serf node name.
+func (s *Serf) nodeByName(name string) (*memberlist.Node, bool) {
+	for _, n := range s.memberlist.Members() {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
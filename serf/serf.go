@@ -0,0 +1,939 @@
+// Package serf provides decentralized cluster membership, failure
+// detection, and event broadcast on top of memberlist's gossip protocol.
+// This file holds the core Serf type and the lamport-clock-ordered
+// join/leave/user-event machinery; the query, coordinate, autopilot, and
+// gateway-relay subsystems elsewhere in the package are all built on top
+// of it.
+package serf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func init() {
+	// Seed the random number generator
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Serf is a single node that is part of a single cluster that gets
+// events about joins/leaves/failures/etc. It is created with the Create
+// method.
+//
+// All functions on the Serf structure are safe to call concurrently.
+type Serf struct {
+	broadcasts    *memberlist.TransmitLimitedQueue
+	clock         LamportClock
+	config        *Config
+	failedMembers []*memberState
+	leftMembers   []*memberState
+	memberlist    *memberlist.Memberlist
+	memberLock    sync.RWMutex
+	members       map[string]*memberState
+
+	// Circular buffers for recent intents, used
+	// in case we get the intent before the relevant event
+	recentLeave      []nodeIntent
+	recentLeaveIndex int
+	recentJoin       []nodeIntent
+	recentJoinIndex  int
+
+	eventBroadcasts *memberlist.TransmitLimitedQueue
+	eventBuffer     []*userEvents
+	eventClock      LamportClock
+	eventLock       sync.RWMutex
+
+	// queryClock, queries and queryID back Serf's internal query
+	// mechanism (see query.go), used both by external callers of Query
+	// and by internal features like the keyring management API.
+	queryClock       LamportClock
+	queryLock        sync.Mutex
+	queries          map[uint32]*QueryResponse
+	queryID          uint32
+	recentQueries    []queryIntent
+	recentQueryIndex int
+
+	// autopilot is non-nil only when Config.Autopilot is set, and is
+	// started and stopped alongside the rest of Serf's background
+	// goroutines.
+	autopilot *autopilot
+
+	// coordClient maintains this node's Vivaldi network coordinate and
+	// a cache of every other member's most recently gossiped
+	// coordinate. See coordinate.go.
+	coordClient *coordClient
+
+	// gatewayListener is non-nil only when Config.GatewayListenAddr is
+	// set, and accepts gateway control connections from other clusters
+	// federating through this node. See gateway.go.
+	gatewayListener net.Listener
+
+	logger     *log.Logger
+	stateLock  sync.Mutex
+	state      SerfState
+	shutdownCh chan struct{}
+}
+
+// SerfState is the state of the Serf instance.
+type SerfState int
+
+const (
+	SerfAlive SerfState = iota
+	SerfLeft
+	SerfShutdown
+)
+
+// Member is a single member of the Serf cluster.
+type Member struct {
+	Name   string
+	Addr   net.IP
+	Role   string
+	Status MemberStatus
+
+	// StatusTime is the wall-clock time at which Status last changed.
+	// It's used by the autopilot subsystem to decide how long a member
+	// has been in its current state.
+	StatusTime time.Time
+}
+
+// MemberStatus is the state that a member is in.
+type MemberStatus int
+
+const (
+	StatusNone MemberStatus = iota
+	StatusAlive
+	StatusLeaving
+	StatusLeft
+	StatusFailed
+)
+
+func (s MemberStatus) String() string {
+	switch s {
+	case StatusNone:
+		return "none"
+	case StatusAlive:
+		return "alive"
+	case StatusLeaving:
+		return "leaving"
+	case StatusLeft:
+		return "left"
+	case StatusFailed:
+		return "failed"
+	default:
+		panic(fmt.Sprintf("unknown MemberStatus: %d", s))
+	}
+}
+
+// memberState is used to track members that are no longer active due to
+// leaving, failing, partitioning, etc. It tracks the member along with
+// when that member was marked as leaving.
+type memberState struct {
+	Member
+	statusLTime LamportTime // lamport clock time of last received message
+	leaveTime   time.Time   // wall clock time of leave
+
+	// relayed is true for a member this node only knows about indirectly,
+	// through a gateway session (see gateway.go), as opposed to one
+	// gossiped in directly over this node's own memberlist cluster.
+	relayed bool
+}
+
+// nodeIntent is used to buffer intents for out-of-order deliveries
+type nodeIntent struct {
+	LTime LamportTime
+	Node  string
+}
+
+// userEvent is used to buffer events to prevent re-delivery
+type userEvent struct {
+	Name    string
+	Payload []byte
+}
+
+func (ue *userEvent) Equals(other *userEvent) bool {
+	if ue.Name != other.Name {
+		return false
+	}
+	return bytes.Equal(ue.Payload, other.Payload)
+}
+
+// userEvents stores all the user events at a specific time
+type userEvents struct {
+	LTime  LamportTime
+	Events []userEvent
+}
+
+const (
+	UserEventSizeLimit = 128 // Maximum byte size for event name and payload
+)
+
+// Create creates a new Serf instance, starting all the background tasks
+// to maintain cluster membership information.
+//
+// After calling this function, the configuration should no longer be used
+// or modified by the caller.
+func Create(conf *Config) (*Serf, error) {
+	if conf.NodeName == "" {
+		return nil, fmt.Errorf("config must have a NodeName")
+	}
+
+	coord, err := newCoordClient()
+	if err != nil {
+		return nil, err
+	}
+
+	serf := &Serf{
+		config:      conf,
+		logger:      log.New(conf.LogOutput, "", log.LstdFlags),
+		members:     make(map[string]*memberState),
+		queries:     make(map[uint32]*QueryResponse),
+		coordClient: coord,
+		shutdownCh:  make(chan struct{}),
+		state:       SerfAlive,
+	}
+
+	// Setup the broadcast queues, which we use to send our own custom
+	// broadcasts along the gossip channel.
+	serf.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       serf.numMembers,
+		RetransmitMult: conf.MemberlistConfig.RetransmitMult,
+	}
+	serf.eventBroadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       serf.numMembers,
+		RetransmitMult: conf.MemberlistConfig.RetransmitMult,
+	}
+
+	// Create the buffer for recent intents
+	serf.recentJoin = make([]nodeIntent, conf.RecentIntentBuffer)
+	serf.recentLeave = make([]nodeIntent, conf.RecentIntentBuffer)
+	serf.recentQueries = make([]queryIntent, conf.RecentIntentBuffer)
+
+	// Create a buffer for events
+	serf.eventBuffer = make([]*userEvents, conf.EventBuffer)
+
+	// Ensure our lamport clocks are at least 1, so that the default
+	// join LTime of 0 does not cause issues
+	serf.clock.Increment()
+	serf.eventClock.Increment()
+	serf.queryClock.Increment()
+
+	// Modify the memberlist configuration with keys that we set
+	conf.MemberlistConfig.Events = &eventDelegate{serf: serf}
+	conf.MemberlistConfig.Delegate = &delegate{serf: serf}
+	conf.MemberlistConfig.Name = conf.NodeName
+	if !conf.DisableCoordinates {
+		conf.MemberlistConfig.Ping = &pingDelegate{serf: serf}
+	}
+
+	// Create the underlying memberlist that will manage membership
+	// and failure detection for the Serf instance.
+	ml, err := memberlist.Create(conf.MemberlistConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	serf.memberlist = ml
+
+	// Start the background tasks. See the documentation above each method
+	// for more information on their role.
+	go serf.handleReap()
+	go serf.handleReconnect()
+	go serf.checkQueueDepth(conf.QueueDepthWarning, "Intent",
+		serf.broadcasts, serf.shutdownCh)
+	go serf.checkQueueDepth(conf.QueueDepthWarning, "Event",
+		serf.eventBroadcasts, serf.shutdownCh)
+
+	if conf.LeaveTimeout > 0 {
+		go serf.handleLeaveTimeout()
+	}
+
+	if conf.Autopilot != nil {
+		serf.autopilot = newAutopilot(serf, conf.Autopilot)
+		go serf.autopilot.run()
+	}
+
+	if !conf.DisableCoordinates {
+		go serf.coordinateLoop(conf.MemberlistConfig.ProbeInterval*10, serf.shutdownCh)
+	}
+
+	if conf.GatewayListenAddr != "" {
+		ln, err := net.Listen("tcp", conf.GatewayListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start gateway listener: %w", err)
+		}
+		serf.gatewayListener = ln
+		go serf.acceptGatewayConns(ln)
+	}
+
+	return serf, nil
+}
+
+// UserEvent is used to broadcast a custom user event with a given
+// name and payload. The events must be fairly small, and if the
+// size limit is exceeded and error will be returned.
+func (s *Serf) UserEvent(name string, payload []byte) error {
+	// Check the size limit
+	if len(name)+len(payload) > UserEventSizeLimit {
+		return fmt.Errorf("user event payload exceeds limit of %d bytes", UserEventSizeLimit)
+	}
+
+	// Create a message
+	msg := messageUserEvent{
+		LTime:   s.eventClock.Time(),
+		Name:    name,
+		Payload: payload,
+	}
+	s.eventClock.Increment()
+
+	// Process update locally
+	s.handleUserEvent(&msg)
+
+	// Start broadcasting the event
+	raw, err := encodeMessage(messageUserEventType, &msg)
+	if err != nil {
+		return err
+	}
+	s.eventBroadcasts.QueueBroadcast(&broadcast{
+		msg: raw,
+	})
+	return nil
+}
+
+// Join joins an existing Serf cluster. Returns the number of nodes
+// successfully contacted. The returned error will be non-nil only in the
+// case that no nodes could be contacted.
+func (s *Serf) Join(existing []string) (int, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.state == SerfShutdown {
+		return 0, fmt.Errorf("Serf can't Join after Shutdown")
+	}
+
+	num, err := s.memberlist.Join(existing)
+
+	// If we joined any nodes, broadcast the join message
+	if num > 0 {
+		msg := messageJoin{
+			LTime: s.clock.Time(),
+			Node:  s.config.NodeName,
+		}
+		s.clock.Increment()
+
+		s.handleNodeJoinIntent(&msg)
+
+		if err := s.broadcastMsg(messageJoinType, &msg, nil); err != nil {
+			return num, err
+		}
+	}
+
+	return num, err
+}
+
+// Leave gracefully exits the cluster. It is safe to call this multiple
+// times.
+func (s *Serf) Leave() error {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.state == SerfLeft {
+		return nil
+	} else if s.state == SerfShutdown {
+		return fmt.Errorf("Leave called after Shutdown")
+	}
+
+	msg := messageLeave{
+		LTime: s.clock.Time(),
+		Node:  s.config.NodeName,
+	}
+	s.clock.Increment()
+
+	s.handleNodeLeaveIntent(&msg)
+
+	if s.hasAliveMembers() {
+		notifyCh := make(chan struct{})
+		if err := s.broadcastMsg(messageLeaveType, &msg, notifyCh); err != nil {
+			return err
+		}
+
+		select {
+		case <-notifyCh:
+		case <-time.After(s.config.BroadcastTimeout):
+			return errors.New("timeout while waiting for graceful leave")
+		}
+	}
+
+	if err := s.memberlist.Leave(s.config.BroadcastTimeout); err != nil {
+		return err
+	}
+
+	s.state = SerfLeft
+	return nil
+}
+
+// hasAliveMembers is called to check for any alive members, not counting
+// the local node.
+func (s *Serf) hasAliveMembers() bool {
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+
+	for _, m := range s.members {
+		if m.Name == s.config.NodeName {
+			continue
+		}
+		if m.Status == StatusAlive {
+			return true
+		}
+	}
+	return false
+}
+
+// numMembers returns the current member count, used to size broadcast
+// retransmit limits. It's called from the memberlist package's own
+// goroutines, so it takes memberLock itself rather than relying on a
+// caller that already holds it.
+func (s *Serf) numMembers() int {
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+	return len(s.members)
+}
+
+// Members returns a point-in-time snapshot of the members of this cluster.
+func (s *Serf) Members() []Member {
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+
+	members := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m.Member)
+	}
+
+	return members
+}
+
+// nativeMembers returns a snapshot of this cluster's real members -- the
+// ones gossiped in over memberlist, not the ones this node has learned
+// about indirectly through a gateway session. It's used to build the
+// membership snapshot a gateway session sends to its peer, so a relayed-in
+// member is never reflected back out and re-relayed as if it were native
+// to this cluster.
+func (s *Serf) nativeMembers() []Member {
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+
+	members := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		if m.relayed {
+			continue
+		}
+		members = append(members, m.Member)
+	}
+
+	return members
+}
+
+// RemoveFailedNode forcibly removes a failed node from the cluster
+// immediately, instead of waiting for the reaper to eventually reclaim it.
+func (s *Serf) RemoveFailedNode(node string) error {
+	msg := messageLeave{
+		LTime: s.clock.Time(),
+		Node:  node,
+	}
+	s.clock.Increment()
+
+	s.handleNodeLeaveIntent(&msg)
+
+	if !s.hasAliveMembers() {
+		return nil
+	}
+
+	notifyCh := make(chan struct{})
+	if err := s.broadcastMsg(messageLeaveType, &msg, notifyCh); err != nil {
+		return err
+	}
+
+	select {
+	case <-notifyCh:
+	case <-time.After(s.config.BroadcastTimeout):
+		return fmt.Errorf("timed out broadcasting node removal")
+	}
+
+	return nil
+}
+
+// Shutdown forcefully shuts down the Serf instance, stopping all network
+// activity and background maintenance associated with the instance.
+//
+// This is not a graceful shutdown, and should be preceded by a call to
+// Leave. Otherwise, other nodes in the cluster will detect this node's
+// exit as a node failure.
+//
+// It is safe to call this method multiple times.
+func (s *Serf) Shutdown() error {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.state == SerfShutdown {
+		return nil
+	}
+
+	if s.state != SerfLeft {
+		s.logger.Println("[WARN] serf: Shutdown without a Leave")
+	}
+
+	if err := s.memberlist.Shutdown(); err != nil {
+		return err
+	}
+
+	if s.autopilot != nil {
+		s.autopilot.Stop()
+	}
+
+	if s.gatewayListener != nil {
+		s.gatewayListener.Close()
+	}
+
+	s.state = SerfShutdown
+	close(s.shutdownCh)
+	return nil
+}
+
+// State is the current state of this Serf instance.
+func (s *Serf) State() SerfState {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.state
+}
+
+// fireEvent delivers a single-member MemberEvent of the given type to
+// Config.EventCh, if one was configured. It's used for events, like
+// EventMemberReap, that don't originate from handleNodeJoin/handleNodeLeave.
+func (s *Serf) fireEvent(t EventType, m *Member) {
+	if s.config.EventCh == nil {
+		return
+	}
+	s.config.EventCh <- MemberEvent{
+		Type:    t,
+		Members: []Member{*m},
+	}
+}
+
+// broadcast queues an already-encoded Serf message (see encodeMessage)
+// for gossip. If a notify channel is given, it is closed once the broadcast
+// is sent.
+func (s *Serf) broadcast(raw []byte, notify chan<- struct{}) error {
+	s.broadcasts.QueueBroadcast(&broadcast{
+		msg:    raw,
+		notify: notify,
+	})
+	return nil
+}
+
+// broadcastMsg takes a Serf message type, encodes it for the wire, and
+// queues the broadcast. It's a convenience wrapper around broadcast for
+// callers that haven't already encoded their message.
+func (s *Serf) broadcastMsg(t messageType, msg interface{}, notify chan<- struct{}) error {
+	raw, err := encodeMessage(t, msg)
+	if err != nil {
+		return err
+	}
+	return s.broadcast(raw, notify)
+}
+
+// handleNodeJoin is called when a node join event is received
+// from memberlist.
+func (s *Serf) handleNodeJoin(n *memberlist.Node) {
+	s.memberLock.Lock()
+	defer s.memberLock.Unlock()
+
+	var oldStatus MemberStatus
+	member, ok := s.members[n.Name]
+	if !ok {
+		oldStatus = StatusNone
+		member = &memberState{
+			Member: Member{
+				Name:       n.Name,
+				Addr:       net.IP(n.Addr),
+				Role:       string(n.Meta),
+				Status:     StatusAlive,
+				StatusTime: time.Now(),
+			},
+		}
+
+		if join := recentIntent(s.recentJoin, n.Name); join != nil {
+			member.statusLTime = join.LTime
+		}
+
+		if leave := recentIntent(s.recentLeave, n.Name); leave != nil {
+			if leave.LTime > member.statusLTime {
+				member.Status = StatusLeaving
+				member.statusLTime = leave.LTime
+			}
+		}
+
+		s.members[n.Name] = member
+	} else {
+		oldStatus = member.Status
+		member.Status = StatusAlive
+		member.StatusTime = time.Now()
+		member.leaveTime = time.Time{}
+	}
+
+	if oldStatus == StatusFailed {
+		s.failedMembers = removeOldMember(s.failedMembers, member.Name)
+		s.leftMembers = removeOldMember(s.leftMembers, member.Name)
+	}
+
+	s.logger.Printf("[INFO] serf: EventMemberJoin: %s %s",
+		member.Member.Name, member.Member.Addr)
+	if s.config.EventCh != nil {
+		s.config.EventCh <- MemberEvent{
+			Type:    EventMemberJoin,
+			Members: []Member{member.Member},
+		}
+	}
+}
+
+// handleNodeLeave is called when a node leave event is received
+// from memberlist.
+func (s *Serf) handleNodeLeave(n *memberlist.Node) {
+	s.memberLock.Lock()
+	defer s.memberLock.Unlock()
+
+	member, ok := s.members[n.Name]
+	if !ok {
+		return
+	}
+
+	switch member.Status {
+	case StatusLeaving:
+		member.Status = StatusLeft
+		member.leaveTime = time.Now()
+		member.StatusTime = member.leaveTime
+		s.leftMembers = append(s.leftMembers, member)
+	case StatusAlive:
+		member.Status = StatusFailed
+		member.leaveTime = time.Now()
+		member.StatusTime = member.leaveTime
+		s.failedMembers = append(s.failedMembers, member)
+	default:
+		s.logger.Printf("[WARN] serf: Bad state when leave: %d", member.Status)
+		return
+	}
+
+	event := EventMemberLeave
+	eventStr := "EventMemberLeave"
+	if member.Status != StatusLeft {
+		event = EventMemberFailed
+		eventStr = "EventMemberFailed"
+	}
+
+	s.logger.Printf("[INFO] serf: %s: %s %s",
+		eventStr, member.Member.Name, member.Member.Addr)
+	if s.config.EventCh != nil {
+		s.config.EventCh <- MemberEvent{
+			Type:    event,
+			Members: []Member{member.Member},
+		}
+	}
+}
+
+// handleNodeLeaveIntent is called when an intent to leave is received.
+func (s *Serf) handleNodeLeaveIntent(leaveMsg *messageLeave) bool {
+	s.clock.Witness(leaveMsg.LTime)
+
+	s.memberLock.Lock()
+	defer s.memberLock.Unlock()
+
+	member, ok := s.members[leaveMsg.Node]
+	if !ok {
+		if recentIntent(s.recentLeave, leaveMsg.Node) != nil {
+			return false
+		}
+
+		s.recentLeave[s.recentLeaveIndex] = nodeIntent{
+			LTime: leaveMsg.LTime,
+			Node:  leaveMsg.Node,
+		}
+		s.recentLeaveIndex = (s.recentLeaveIndex + 1) % len(s.recentLeave)
+		return true
+	}
+
+	if leaveMsg.LTime <= member.statusLTime {
+		return false
+	}
+
+	switch member.Status {
+	case StatusAlive:
+		member.Status = StatusLeaving
+		member.statusLTime = leaveMsg.LTime
+		member.StatusTime = time.Now()
+		return true
+	case StatusFailed:
+		member.Status = StatusLeft
+		member.statusLTime = leaveMsg.LTime
+		member.StatusTime = time.Now()
+
+		s.failedMembers = removeOldMember(s.failedMembers, member.Name)
+		s.leftMembers = append(s.leftMembers, member)
+
+		return true
+	default:
+		return false
+	}
+}
+
+// handleNodeJoinIntent is called when a node broadcasts a
+// join message to set the lamport time of its join
+func (s *Serf) handleNodeJoinIntent(joinMsg *messageJoin) bool {
+	s.clock.Witness(joinMsg.LTime)
+
+	s.memberLock.Lock()
+	defer s.memberLock.Unlock()
+
+	member, ok := s.members[joinMsg.Node]
+	if !ok {
+		if recentIntent(s.recentJoin, joinMsg.Node) != nil {
+			return false
+		}
+
+		s.recentJoin[s.recentJoinIndex] = nodeIntent{LTime: joinMsg.LTime, Node: joinMsg.Node}
+		s.recentJoinIndex = (s.recentJoinIndex + 1) % len(s.recentJoin)
+		return true
+	}
+
+	if joinMsg.LTime <= member.statusLTime {
+		return false
+	}
+
+	member.statusLTime = joinMsg.LTime
+
+	// If we are in the leaving state, we should go back to alive,
+	// since the leaving message must have been for an older time
+	if member.Status == StatusLeaving {
+		member.Status = StatusAlive
+		member.StatusTime = time.Now()
+	}
+	return true
+}
+
+// handleUserEvent is called when a user event broadcast is
+// received. Returns if the message should be rebroadcast.
+func (s *Serf) handleUserEvent(eventMsg *messageUserEvent) bool {
+	s.eventClock.Witness(eventMsg.LTime)
+
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+
+	curTime := s.eventClock.Time()
+	if curTime > LamportTime(len(s.eventBuffer)) &&
+		eventMsg.LTime < curTime-LamportTime(len(s.eventBuffer)) {
+		s.logger.Printf(
+			"[WARN] serf: received old event %s from time %d (current: %d)",
+			eventMsg.Name,
+			eventMsg.LTime,
+			s.eventClock.Time())
+		return false
+	}
+
+	idx := eventMsg.LTime % LamportTime(len(s.eventBuffer))
+	seen := s.eventBuffer[idx]
+	ue := userEvent{Name: eventMsg.Name, Payload: eventMsg.Payload}
+	if seen != nil && seen.LTime == eventMsg.LTime {
+		for _, previous := range seen.Events {
+			if previous.Equals(&ue) {
+				return false
+			}
+		}
+	} else {
+		seen = &userEvents{LTime: eventMsg.LTime}
+		s.eventBuffer[idx] = seen
+	}
+
+	seen.Events = append(seen.Events, ue)
+
+	if s.config.EventCh != nil {
+		s.config.EventCh <- UserEvent{
+			Name:    eventMsg.Name,
+			Payload: eventMsg.Payload,
+		}
+	}
+	return true
+}
+
+// handleReap periodically reaps the list of failed and left members.
+func (s *Serf) handleReap() {
+	for {
+		select {
+		case <-time.After(s.config.ReapInterval):
+			s.memberLock.Lock()
+			s.failedMembers = s.reap(s.failedMembers, s.config.ReconnectTimeout)
+			s.leftMembers = s.reap(s.leftMembers, s.config.TombstoneTimeout)
+			s.memberLock.Unlock()
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// handleReconnect attempts to reconnect to recently failed nodes
+// on configured intervals.
+func (s *Serf) handleReconnect() {
+	for {
+		select {
+		case <-time.After(s.config.ReconnectInterval):
+			s.reconnect()
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// handleLeaveTimeout watches for members stuck in StatusLeaving for
+// longer than Config.LeaveTimeout, and resets them back to StatusAlive.
+// This guards against a leave intent that never gets confirmed by a
+// corresponding memberlist leave/failure event -- for example, a leave
+// broadcast that the node itself subsequently rejoined before it took
+// effect.
+func (s *Serf) handleLeaveTimeout() {
+	ticker := time.NewTicker(s.config.LeaveTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.resetStaleLeaveIntents()
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+func (s *Serf) resetStaleLeaveIntents() {
+	s.memberLock.Lock()
+	var reset []Member
+	now := time.Now()
+	for _, m := range s.members {
+		if m.Status == StatusLeaving && now.Sub(m.StatusTime) >= s.config.LeaveTimeout {
+			m.Status = StatusAlive
+			m.StatusTime = now
+			reset = append(reset, m.Member)
+		}
+	}
+	s.memberLock.Unlock()
+
+	for _, m := range reset {
+		mCopy := m
+		s.logger.Printf("[INFO] serf: leave intent for %s timed out, resetting to alive", m.Name)
+		s.fireEvent(EventMemberJoin, &mCopy)
+	}
+}
+
+// reap is called with a list of old members and a timeout, and removes
+// members that have exceeded the timeout. The members are removed from
+// both the old list and the members itself. Locking is left to the caller.
+func (s *Serf) reap(old []*memberState, timeout time.Duration) []*memberState {
+	now := time.Now()
+	n := len(old)
+	for i := 0; i < n; i++ {
+		m := old[i]
+
+		if now.Sub(m.leaveTime) <= timeout {
+			continue
+		}
+
+		old[i], old[n-1] = old[n-1], nil
+		old = old[:n-1]
+		n--
+		i--
+
+		delete(s.members, m.Name)
+	}
+
+	return old
+}
+
+// reconnect attempts to reconnect to recently fail nodes.
+func (s *Serf) reconnect() {
+	s.memberLock.RLock()
+
+	n := len(s.failedMembers)
+	if n == 0 {
+		s.memberLock.RUnlock()
+		return
+	}
+
+	numFailed := float32(len(s.failedMembers))
+	numAlive := float32(len(s.members) - len(s.failedMembers) - len(s.leftMembers))
+	if numAlive == 0 {
+		numAlive = 1
+	}
+	prob := numFailed / numAlive
+	if rand.Float32() > prob {
+		s.memberLock.RUnlock()
+		return
+	}
+
+	idx := int(rand.Uint32() % uint32(n))
+	mem := s.failedMembers[idx]
+	s.memberLock.RUnlock()
+	s.logger.Printf("[INFO] serf: attempting reconnect to %v %v", mem.Name, net.IP(mem.Addr))
+
+	addr := mem.Addr.String()
+	s.memberlist.Join([]string{addr})
+}
+
+// checkQueueDepth periodically checks the size of a queue to see if
+// it is too large
+func (s *Serf) checkQueueDepth(limit int, name string, queue *memberlist.TransmitLimitedQueue, shutdownCh chan struct{}) {
+	for {
+		select {
+		case <-time.After(time.Second):
+			numq := queue.NumQueued()
+			if numq >= limit {
+				s.logger.Printf("[WARN] serf: %s queue depth: %d", name, numq)
+			}
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+// removeOldMember is used to remove an old member from a list of old
+// members.
+func removeOldMember(old []*memberState, name string) []*memberState {
+	for i, m := range old {
+		if m.Name == name {
+			n := len(old)
+			old[i], old[n-1] = old[n-1], nil
+			return old[:n-1]
+		}
+	}
+
+	return old
+}
+
+// recentIntent checks the recent intent buffer for a matching
+// entry for a given node, and either returns the message or nil
+func recentIntent(recent []nodeIntent, node string) (intent *nodeIntent) {
+	for i := 0; i < len(recent); i++ {
+		if recent[i].LTime == 0 {
+			break
+		}
+
+		if recent[i].Node == node {
+			if intent == nil || recent[i].LTime > intent.LTime {
+				intent = &recent[i]
+			}
+		}
+	}
+	return
+}
@@ -2,10 +2,34 @@ package serf
 
 import (
 	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/tristanmorgan/serf/serf/testutil"
+)
+
+var (
+	bindLock sync.Mutex
+	bindNum  byte = 10
 )
 
+// getBindAddr returns a loopback address unique to the calling test, so
+// that parallel tests don't collide trying to bind the same port.
+func getBindAddr() net.IP {
+	bindLock.Lock()
+	defer bindLock.Unlock()
+
+	result := net.IPv4(127, 0, 0, bindNum)
+	bindNum++
+	if bindNum > 255 {
+		bindNum = 10
+	}
+
+	return result
+}
+
 func testConfig() *Config {
 	config := DefaultConfig()
 	config.MemberlistConfig.BindAddr = getBindAddr().String()
@@ -51,8 +75,75 @@ func testMember(t *testing.T, members []Member, name string, status MemberStatus
 	t.Fatalf("node not found: %s", name)
 }
 
-func yield() {
-	time.Sleep(5 * time.Millisecond)
+// waitForMemberCount polls s until it reports exactly n members, failing
+// the test if that doesn't happen before the deadline.
+func waitForMemberCount(t *testing.T, s *Serf, n int) {
+	t.Helper()
+
+	err := testutil.WaitForResult(func() (bool, error) {
+		if len(s.Members()) == n {
+			return true, nil
+		}
+		return false, fmt.Errorf("expected %d members, got %d", n, len(s.Members()))
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForMemberStatus polls s until member name is reported with status,
+// failing the test if that doesn't happen before the deadline.
+func waitForMemberStatus(t *testing.T, s *Serf, name string, status MemberStatus) {
+	t.Helper()
+
+	err := testutil.WaitForResult(func() (bool, error) {
+		for _, m := range s.Members() {
+			if m.Name == name {
+				if m.Status == status {
+					return true, nil
+				}
+				return false, fmt.Errorf("%s has status %d, want %d", name, m.Status, status)
+			}
+		}
+		return false, fmt.Errorf("%s not found", name)
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertEvents waits for exactly len(want) events concerning node to
+// arrive on ch, in order, and fails the test if the wrong type arrives or
+// if timeout elapses first. Events for other nodes are ignored, since
+// most tests only care about one node's transitions. It lives here,
+// rather than in serf/testutil, because it needs the serf package's own
+// Event types and testutil must not import serf (serf's own tests import
+// testutil, which would be a cycle).
+func assertEvents(t *testing.T, ch <-chan Event, node string, want []EventType, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	got := 0
+
+	for got < len(want) {
+		select {
+		case e := <-ch:
+			me, ok := e.(MemberEvent)
+			if !ok || len(me.Members) == 0 || me.Members[0].Name != node {
+				continue
+			}
+
+			if me.Type != want[got] {
+				t.Fatalf("event %d for %s: got %s, want %s", got, node, me.Type, want[got])
+				return
+			}
+			got++
+
+		case <-deadline:
+			t.Fatalf("timed out after %s waiting for event %d/%d for %s", timeout, got, len(want), node)
+			return
+		}
+	}
 }
 
 func TestSerfCreate_noName(t *testing.T) {
@@ -88,24 +179,22 @@ func TestSerf_eventsFailed(t *testing.T) {
 	defer s1.Shutdown()
 	defer s2.Shutdown()
 
-	yield()
-
 	_, err = s1.Join([]string{s2Config.MemberlistConfig.BindAddr})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
+	waitForMemberCount(t, s1, 2)
 
 	if err := s2.Shutdown(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	time.Sleep(1 * time.Second)
+	waitForMemberStatus(t, s1, s2Config.NodeName, StatusFailed)
 
 	// Since s2 shutdown, we check the events to make sure we got failures.
-	testEvents(t, eventCh, s2Config.NodeName,
-		[]EventType{EventMemberJoin, EventMemberFailed})
+	assertEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin, EventMemberFailed}, 5*time.Second)
 }
 
 func TestSerf_eventsJoin(t *testing.T) {
@@ -129,17 +218,13 @@ func TestSerf_eventsJoin(t *testing.T) {
 	defer s1.Shutdown()
 	defer s2.Shutdown()
 
-	yield()
-
 	_, err = s1.Join([]string{s2Config.MemberlistConfig.BindAddr})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
-
-	testEvents(t, eventCh, s2Config.NodeName,
-		[]EventType{EventMemberJoin})
+	assertEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin}, 5*time.Second)
 }
 
 func TestSerf_eventsLeave(t *testing.T) {
@@ -163,25 +248,21 @@ func TestSerf_eventsLeave(t *testing.T) {
 	defer s1.Shutdown()
 	defer s2.Shutdown()
 
-	yield()
-
 	_, err = s1.Join([]string{s2Config.MemberlistConfig.BindAddr})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
+	waitForMemberCount(t, s1, 2)
 
 	if err := s2.Leave(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
-
 	// Now that s2 has left, we check the events to make sure we got
 	// a leave event in s1 about the leave.
-	testEvents(t, eventCh, s2Config.NodeName,
-		[]EventType{EventMemberJoin, EventMemberLeave})
+	assertEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin, EventMemberLeave}, 5*time.Second)
 }
 
 func TestSerf_joinLeave(t *testing.T) {
@@ -201,46 +282,25 @@ func TestSerf_joinLeave(t *testing.T) {
 	defer s1.Shutdown()
 	defer s2.Shutdown()
 
-	yield()
-
-	if len(s1.Members()) != 1 {
-		t.Fatalf("s1 members: %d", len(s1.Members()))
-	}
-
-	if len(s2.Members()) != 1 {
-		t.Fatalf("s2 members: %d", len(s2.Members()))
-	}
+	waitForMemberCount(t, s1, 1)
+	waitForMemberCount(t, s2, 1)
 
 	_, err = s1.Join([]string{s2Config.MemberlistConfig.BindAddr})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
-
-	if len(s1.Members()) != 2 {
-		t.Fatalf("s1 members: %d", len(s1.Members()))
-	}
-
-	if len(s2.Members()) != 2 {
-		t.Fatalf("s2 members: %d", len(s2.Members()))
-	}
+	waitForMemberCount(t, s1, 2)
+	waitForMemberCount(t, s2, 2)
 
 	err = s1.Leave()
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	// Give the reaper time to reap nodes
-	time.Sleep(s1Config.ReapInterval * 2)
-
-	if len(s1.Members()) != 1 {
-		t.Fatalf("s1 members: %d", len(s1.Members()))
-	}
-
-	if len(s2.Members()) != 1 {
-		t.Fatalf("s2 members: %d", len(s2.Members()))
-	}
+	// Wait for the reaper to reap nodes.
+	waitForMemberCount(t, s1, 1)
+	waitForMemberCount(t, s2, 1)
 }
 
 func TestSerf_reconnect(t *testing.T) {
@@ -265,21 +325,19 @@ func TestSerf_reconnect(t *testing.T) {
 	defer s1.Shutdown()
 	defer s2.Shutdown()
 
-	yield()
-
 	_, err = s1.Join([]string{s2Config.MemberlistConfig.BindAddr})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
+	waitForMemberCount(t, s1, 2)
 
 	// Now force the shutdown of s2 so it appears to fail.
 	if err := s2.Shutdown(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	time.Sleep(s2Config.MemberlistConfig.ProbeInterval * 5)
+	waitForMemberStatus(t, s1, s2Name, StatusFailed)
 
 	// Bring back s2 by mimicking its name and address
 	s2Config = testConfig()
@@ -290,10 +348,10 @@ func TestSerf_reconnect(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	time.Sleep(s1Config.ReconnectInterval * 5)
+	waitForMemberStatus(t, s1, s2Name, StatusAlive)
 
-	testEvents(t, eventCh, s2Name,
-		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin})
+	assertEvents(t, eventCh, s2Name,
+		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin}, 5*time.Second)
 }
 
 // internals
@@ -307,10 +365,11 @@ func TestSerf_resetLeaveIntent(t *testing.T) {
 	}
 	defer s1.Shutdown()
 
-	yield()
+	waitForMemberCount(t, s1, 1)
 
 	s1.handleNodeLeaveIntent(&messageLeave{
-		Node: s1Config.NodeName,
+		LTime: s1.clock.Time(),
+		Node:  s1Config.NodeName,
 	})
 
 	members := s1.Members()
@@ -318,12 +377,7 @@ func TestSerf_resetLeaveIntent(t *testing.T) {
 		t.Fatalf("status should be leaving: %d", members[0].Status)
 	}
 
-	time.Sleep(s1Config.LeaveTimeout + 10*time.Millisecond)
-
-	members = s1.Members()
-	if members[0].Status == StatusLeaving {
-		t.Fatalf("status should not be leaving: %d", members[0].Status)
-	}
+	waitForMemberStatus(t, s1, s1Config.NodeName, StatusAlive)
 }
 
 func TestSerf_role(t *testing.T) {
@@ -351,13 +405,9 @@ func TestSerf_role(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
+	waitForMemberCount(t, s1, 2)
 
 	members := s1.Members()
-	if len(members) != 2 {
-		t.Fatalf("should have 2 members")
-	}
-
 	roles := make(map[string]string)
 	for _, m := range members {
 		roles[m.Name] = m.Role
@@ -406,17 +456,15 @@ func TestSerfRemoveFailedNode(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	yield()
+	waitForMemberCount(t, s1, 3)
 
 	// Now force the shutdown of s2 so it appears to fail.
 	if err := s2.Shutdown(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	time.Sleep(s2Config.MemberlistConfig.ProbeInterval * 5)
-
 	// Verify that s2 is "failed"
-	testMember(t, s1.Members(), s2Config.NodeName, StatusFailed)
+	waitForMemberStatus(t, s1, s2Config.NodeName, StatusFailed)
 
 	// Now remove the failed node
 	if err := s1.RemoveFailedNode(s2Config.NodeName); err != nil {
@@ -424,8 +472,8 @@ func TestSerfRemoveFailedNode(t *testing.T) {
 	}
 
 	// Verify that s2 is gone
-	testMember(t, s1.Members(), s2Config.NodeName, StatusLeft)
-	testMember(t, s3.Members(), s2Config.NodeName, StatusLeft)
+	waitForMemberStatus(t, s1, s2Config.NodeName, StatusLeft)
+	waitForMemberStatus(t, s3, s2Config.NodeName, StatusLeft)
 }
 
 func TestSerfState(t *testing.T) {
@@ -454,4 +502,4 @@ func TestSerfState(t *testing.T) {
 	if s1.State() != SerfShutdown {
 		t.Fatalf("bad state: %d", s1.State())
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,124 @@
+// Package testutil provides small helpers for writing Serf tests that poll
+// for a condition instead of sleeping for a fixed duration. Serf's gossip
+// and failure detection timing is inherently asynchronous, so tests that
+// sleep for "probably long enough" are flaky under load; these helpers
+// retry until either the condition is met or a hard deadline passes.
+package testutil
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// WaitForResult polls test until it returns true, or returns an error
+// wrapping the last error reported by test once timeout elapses. It's meant for simple,
+// single-condition waits; for anything that needs t.Fatalf-style
+// reporting and retry semantics, use Retry instead.
+func WaitForResult(test func() (bool, error), timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wait := 5 * time.Millisecond
+
+	var err error
+	for time.Now().Before(deadline) {
+		var ok bool
+		if ok, err = test(); ok {
+			return nil
+		}
+
+		time.Sleep(wait)
+		if wait < 500*time.Millisecond {
+			wait *= 2
+		}
+	}
+
+	return fmt.Errorf("timed out waiting for result: %w", err)
+}
+
+// R is passed to the function given to Retry. Calling Fatal/Fatalf/Error
+// on it marks the current attempt as failed; only Fatal/Fatalf abort the
+// attempt immediately.
+type R struct {
+	fail   bool
+	output []string
+}
+
+func (r *R) Error(args ...interface{}) {
+	r.fail = true
+	r.output = append(r.output, fmt.Sprint(args...))
+}
+
+func (r *R) Errorf(format string, args ...interface{}) {
+	r.fail = true
+	r.output = append(r.output, fmt.Sprintf(format, args...))
+}
+
+func (r *R) Fatal(args ...interface{}) {
+	r.fail = true
+	r.output = append(r.output, fmt.Sprint(args...))
+	runtime.Goexit()
+}
+
+func (r *R) Fatalf(format string, args ...interface{}) {
+	r.fail = true
+	r.output = append(r.output, fmt.Sprintf(format, args...))
+	runtime.Goexit()
+}
+
+// Check is a convenience for the common "fail the attempt if err != nil"
+// case.
+func (r *R) Check(err error) {
+	if err != nil {
+		r.Fatal(err)
+	}
+}
+
+// Retry runs fn repeatedly with an exponential backoff until it completes
+// without calling Fatal/Fatalf/Error on its *R, or until the overall
+// deadline is reached, in which case the last attempt's failures are
+// reported via t.Fatalf.
+func Retry(t *testing.T, fn func(r *R)) {
+	t.Helper()
+
+	deadline := time.Now().Add(7 * time.Second)
+	wait := 25 * time.Millisecond
+
+	for {
+		r := &R{}
+		attempt(r, fn)
+
+		if !r.fail {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out retrying:\n%s", joinLines(r.output))
+			return
+		}
+
+		time.Sleep(wait)
+		if wait < time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// attempt runs fn in its own goroutine so that r.Fatal's runtime.Goexit
+// only unwinds that attempt, not the calling test.
+func attempt(r *R, fn func(r *R)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+}
+
+func joinLines(lines []string) string {
+	out := ""
+	for _, l := range lines {
+		out += "  " + l + "\n"
+	}
+	return out
+}